@@ -0,0 +1,40 @@
+package common
+
+//CloseReason 连接关闭的原因
+type CloseReason int
+
+const (
+	CloseNone             CloseReason = iota // 还未关闭
+	CloseActive                              // 服务端主动调用Close
+	ClosePeer                                // 对端正常关闭(FIN、websocket close帧)
+	CloseReadError                           // 读取出错，包括协议错误、TLS握手失败
+	CloseWriteError                          // 写入出错
+	CloseIdleTimeout                         // 空闲超时
+	CloseHeartbeatTimeout                    // 应用层心跳超时
+	CloseShutdown                            // 服务关闭
+	CloseRejected                            // 超过限制被拒绝，如：消息队列、异步发送队列已满
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNone:
+		return "none"
+	case CloseActive:
+		return "active"
+	case ClosePeer:
+		return "peer"
+	case CloseReadError:
+		return "read error"
+	case CloseWriteError:
+		return "write error"
+	case CloseIdleTimeout:
+		return "idle timeout"
+	case CloseHeartbeatTimeout:
+		return "heartbeat timeout"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
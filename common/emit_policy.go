@@ -0,0 +1,10 @@
+package common
+
+//EmitPolicy 消息队列已满时，事件循环的处理方式
+type EmitPolicy = int
+
+const (
+	EmitBlock       EmitPolicy = iota // 阻塞等待，默认
+	EmitDropOldest                    // 丢弃队列中最早的消息
+	EmitRejectClose                   // 丢弃这条消息并关闭连接
+)
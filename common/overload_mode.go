@@ -0,0 +1,10 @@
+package common
+
+//OverloadMode 积压的消息达到高水位时，对新消息的处理方式
+type OverloadMode = int
+
+const (
+	OverloadQueue  OverloadMode = iota // 继续排队等待处理，默认
+	OverloadReject                     // 回复指定的数据包，不分发到路由
+	OverloadDrop                       // 直接丢弃，不分发到路由
+)
@@ -0,0 +1,10 @@
+package common
+
+//SendQueuePolicy 异步发送队列已满时的处理方式
+type SendQueuePolicy = int
+
+const (
+	SendQueueReject SendQueuePolicy = iota // 返回错误，默认
+	SendQueueDrop                          // 丢弃这条消息，不返回错误
+	SendQueueClose                         // 关闭连接
+)
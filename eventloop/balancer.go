@@ -0,0 +1,55 @@
+package eventloop
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//roundRobin 依次分配
+type roundRobin struct {
+	next uint64
+}
+
+//RoundRobin 依次分配到每个事件循环，默认策略
+func RoundRobin() iface.ILoopBalancer {
+	return new(roundRobin)
+}
+
+func (r *roundRobin) Select(conn iface.IConnect, loads []int) int {
+	return int((atomic.AddUint64(&r.next, 1) - 1) % uint64(len(loads)))
+}
+
+//leastConnections 分配到连接数最少的事件循环
+type leastConnections struct{}
+
+//LeastConnections 分配到当前连接数最少的事件循环
+func LeastConnections() iface.ILoopBalancer {
+	return leastConnections{}
+}
+
+func (leastConnections) Select(conn iface.IConnect, loads []int) int {
+	index := 0
+	for i, load := range loads {
+		if load < loads[index] {
+			index = i
+		}
+	}
+	return index
+}
+
+//sourceHash 根据对端ip分配
+type sourceHash struct{}
+
+//SourceHash 根据对端ip的hash分配，同一个ip的连接会分配到同一个事件循环
+func SourceHash() iface.ILoopBalancer {
+	return sourceHash{}
+}
+
+func (sourceHash) Select(conn iface.IConnect, loads []int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(util.AddrIP(conn.GetAddress())))
+	return int(hash.Sum32() % uint32(len(loads)))
+}
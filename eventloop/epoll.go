@@ -3,22 +3,45 @@
 package eventloop
 
 import (
-	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/ikilobyte/netman/util"
 
+	"github.com/ikilobyte/netman/common"
 	"github.com/ikilobyte/netman/iface"
 	"golang.org/x/sys/unix"
 )
 
+//wakeValue eventfd的计数是本机字节序的uint64，每次唤醒加1
+var wakeValue = func() []byte {
+	bs := make([]byte, 8)
+	*(*uint64)(unsafe.Pointer(&bs[0])) = 1
+	return bs
+}()
+
 type Poller struct {
-	Epfd       int                   // eventpoll fd
-	Events     []unix.EpollEvent     //
-	ConnectMgr iface.IConnectManager //
+	Epfd          int                   // eventpoll fd
+	Events        []unix.EpollEvent     //
+	ConnectMgr    iface.IConnectManager //
+	conns         int32                 // 当前管理的连接数量
+	logger        iface.ILogger         // 日志
+	emitPolicy    common.EmitPolicy     // 消息队列已满时的处理方式
+	edgeTriggered bool                  // 是否为边缘触发
+	batchDispatch bool                  // 是否批量投递消息
+	waitTimeout   time.Duration         // wait的超时时间，<= 0 表示一直阻塞
+	stopped       int32                 // 是否已停止
+	wakeFd        int                   // 用来唤醒wait的eventfd，-1表示未创建
+	tasks         []func()              // Submit提交的任务，被唤醒后在事件循环中执行
+	taskLock      sync.Mutex            // 保护tasks、wakeFd
+	onError       iface.ErrorFunc       // 连接出现读写错误时回调
+	done          chan struct{}         // 停止时关闭，阻塞在emit中的发送会返回
 }
 
 //NewPoller 创建epoll
-func NewPoller(connectMgr iface.IConnectManager) (*Poller, error) {
+func NewPoller(connectMgr iface.IConnectManager, logger iface.ILogger) (*Poller, error) {
 
 	fd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
 	if err != nil {
@@ -29,21 +52,32 @@ func NewPoller(connectMgr iface.IConnectManager) (*Poller, error) {
 		Epfd:       fd,
 		Events:     make([]unix.EpollEvent, 128),
 		ConnectMgr: connectMgr,
+		logger:     logger,
+		wakeFd:     -1,
+		done:       make(chan struct{}),
 	}, nil
 }
 
 //Wait 等待消息到达，通过通道传递出去
-func (p *Poller) Wait(emitCh chan<- iface.IContext) {
+func (p *Poller) Wait(emitCh chan iface.IContext) {
+
+	defer p.closeWake()
 
 	for {
 		// n有三种情况，-1，0，> 0
-		n, err := unix.EpollWait(p.Epfd, p.Events, -1)
+		n, err := unix.EpollWait(p.Epfd, p.Events, p.waitMsec())
+
+		// 已停止，epfd已关闭或即将关闭
+		if p.isStopped() {
+			return
+		}
+
 		if err != nil {
 			if err == unix.EAGAIN || err == unix.EINTR {
 				continue
 			}
 
-			util.Logger.WithField("epfd", p.Epfd).WithField("error", err).Error("epoll_wait error")
+			p.logger.WithFields(map[string]interface{}{"epfd": p.Epfd, "error": err}).Errorf("epoll_wait error")
 			// 断开这个epoll管理的所有连接
 			p.ConnectMgr.ClearByEpFd(p.Epfd)
 			return
@@ -58,6 +92,13 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 				connEvent iface.IConnectEvent
 			)
 
+			// 被Stop、Submit唤醒
+			if connFd == p.wakeFd {
+				_, _ = unix.Read(connFd, make([]byte, 8))
+				p.runTasks()
+				continue
+			}
+
 			// 1、通过connID获取conn实例
 			if conn = p.ConnectMgr.Get(connFd); conn == nil {
 				// 断开连接
@@ -74,21 +115,32 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 				// 继续写
 				if err := connEvent.ProceedWrite(); err != nil {
 					// 断开连接
+					err = util.WrapSyscallError(util.OpWrite, conn.GetID(), connFd, err)
+					p.fail(conn, common.CloseWriteError, err)
 					_ = conn.Close()
-					util.Logger.Errorf("epoll proceedWrite write error %v", err)
+					p.logger.Errorf("epoll proceedWrite write error %v", err)
 					continue
 				}
 				continue
 			}
 
+			// 已暂停读取，只会收到对端关闭、连接出错的事件(EPOLLHUP、EPOLLERR总是会通知)
+			if event.Events&(unix.EPOLLIN|unix.EPOLLPRI) == 0 {
+				if event.Events&(unix.EPOLLHUP|unix.EPOLLERR) != 0 || (event.Events&unix.EPOLLRDHUP != 0 && conn.ReadPaused()) {
+					p.hangup(conn, connEvent)
+				}
+				continue
+			}
+
 			// 1、判断是否开启tls
 			if conn.GetTLSEnable() && conn.GetHandshakeCompleted() == false {
 
 				tlsConnect := conn.GetTLSLayer()
 				if err := tlsConnect.Handshake(); err != nil {
 					// 断开连接
+					p.fail(conn, common.CloseReadError, err)
 					_ = conn.Close()
-					util.Logger.Errorf("tls handshake error %v", err)
+					p.logger.Errorf("tls handshake error %v", err)
 					continue
 				}
 				// 1、设置状态
@@ -101,45 +153,30 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 				}
 			}
 
-			// 2、非阻塞模式读取一个完整的包
-			message, err := connEvent.DecodePacket()
-			if err != nil {
-				switch err {
-				case io.EOF, util.HeadBytesLengthFail, util.BodyLenExceedLimit:
-					// 断开连接
-					_ = conn.Close()
-				case
-					util.WebsocketOpcodeFail,
-					util.WebsocketRsvFail,
-					util.WebsocketCtrlMessageMustNotFragmented,
-					util.WebsocketProtocolError,
-					util.WebsocketPingPayloadOversize:
-					_ = conn.(iface.IWebsocketCloser).CloseCode(1002, "protocol error.")
-				case util.WebsocketMustUtf8:
-					_ = conn.(iface.IWebsocketCloser).CloseCode(1007, "non-UTF-8 data within a text message")
-				default:
-					continue
-				}
-			}
-
-			if message == nil {
-				continue
-			}
+			// 2、读取数据，对端已关闭写端时一直读取到EOF
+			p.read(conn, connEvent, emitCh, event.Events&unix.EPOLLRDHUP != 0)
+		}
+	}
+}
 
-			// 3、将消息传递出去，交给worker处理（websocket是可以发送payload长度为0的消息）
-			if message.Len() <= 0 && message.IsWebsocket() == false {
-				continue
-			}
+//waitMsec epoll_wait的超时时间，单位毫秒，-1表示一直阻塞
+func (p *Poller) waitMsec() int {
+	if p.waitTimeout <= 0 {
+		return -1
+	}
 
-			emitCh <- util.NewContext(util.NewRequest(conn, message, p.ConnectMgr))
-		}
+	// 不足1毫秒时按1毫秒处理，避免变成0后空转
+	msec := int(p.waitTimeout / time.Millisecond)
+	if msec <= 0 {
+		msec = 1
 	}
+	return msec
 }
 
-//AddRead 添加读事件
+//AddRead 添加读事件，同时监听EPOLLRDHUP，对端关闭写端时可以立即知道
 func (p *Poller) AddRead(fd, connID int) error {
 	return unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
-		Events: unix.EPOLLIN | unix.EPOLLPRI,
+		Events: unix.EPOLLIN | unix.EPOLLPRI | unix.EPOLLRDHUP | p.trigger(),
 		Fd:     int32(fd),
 		Pad:    int32(connID),
 	})
@@ -148,7 +185,7 @@ func (p *Poller) AddRead(fd, connID int) error {
 //AddWrite 添加可写事件
 func (p *Poller) AddWrite(fd, connID int) error {
 	return unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
-		Events: unix.EPOLLOUT,
+		Events: unix.EPOLLOUT | p.trigger(),
 		Fd:     int32(fd),
 		Pad:    int32(connID),
 	})
@@ -157,7 +194,7 @@ func (p *Poller) AddWrite(fd, connID int) error {
 //ModWrite .
 func (p *Poller) ModWrite(fd, connID int) error {
 	return unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
-		Events: unix.EPOLLOUT,
+		Events: unix.EPOLLOUT | p.trigger(),
 		Fd:     int32(fd),
 		Pad:    int32(connID),
 	})
@@ -166,15 +203,78 @@ func (p *Poller) ModWrite(fd, connID int) error {
 //ModRead .
 func (p *Poller) ModRead(fd, connID int) error {
 	return unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
-		Events: unix.EPOLLIN | unix.EPOLLPRI,
+		Events: unix.EPOLLIN | unix.EPOLLPRI | unix.EPOLLRDHUP | p.trigger(),
+		Fd:     int32(fd),
+		Pad:    int32(connID),
+	})
+}
+
+//PauseRead 不再监听读事件，只保留对端关闭(EPOLLRDHUP)，ModWrite、ModRead后会重新监听
+func (p *Poller) PauseRead(fd, connID int) error {
+	return unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
+		Events: unix.EPOLLRDHUP | unix.EPOLLET,
 		Fd:     int32(fd),
 		Pad:    int32(connID),
 	})
 }
 
+//ResumeRead 恢复读事件
+func (p *Poller) ResumeRead(fd, connID int) error {
+	return p.ModRead(fd, connID)
+}
+
+//trigger 边缘触发时需要加上EPOLLET，EPOLL_CTL_MOD后如果仍有数据未读取，会重新通知一次
+func (p *Poller) trigger() uint32 {
+	if p.edgeTriggered {
+		return unix.EPOLLET
+	}
+	return 0
+}
+
 //Remove 删除某个fd的事件
 func (p *Poller) Remove(fd int) error {
-	return unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_DEL, fd, nil)
+	if err := unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_DEL, fd, nil); err != nil {
+		return err
+	}
+	atomic.AddInt32(&p.conns, -1)
+	return nil
+}
+
+//initWake 创建eventfd并添加到epoll，写入eventfd后epoll_wait会立即返回
+func (p *Poller) initWake() error {
+	fd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.EpollCtl(p.Epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
+		Events: unix.EPOLLIN,
+		Fd:     int32(fd),
+	}); err != nil {
+		_ = unix.Close(fd)
+		return err
+	}
+
+	p.wakeFd = fd
+	return nil
+}
+
+//wake 唤醒epoll_wait，调用方需要持有taskLock
+func (p *Poller) wake() {
+	if p.wakeFd < 0 {
+		return
+	}
+	_, _ = unix.Write(p.wakeFd, wakeValue)
+}
+
+//closeWake 关闭eventfd，需要在wait退出后再关闭，否则写入的唤醒事件会随着fd的关闭一起从epoll中移除
+func (p *Poller) closeWake() {
+	p.taskLock.Lock()
+	defer p.taskLock.Unlock()
+	if p.wakeFd >= 0 {
+		_ = unix.Close(p.wakeFd)
+		p.wakeFd = -1
+	}
 }
 
 //Close 关闭FD
@@ -0,0 +1,36 @@
+// +build linux
+
+package eventloop
+
+import (
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWakeCounter(t *testing.T) {
+	p, err := NewPoller(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(p.Epfd)
+	if err := p.initWake(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.closeWake()
+
+	// 每次唤醒计数只加1，多次唤醒不会溢出导致写入失败
+	const wakes = 1000
+	for i := 0; i < wakes; i++ {
+		p.wake()
+	}
+
+	bs := make([]byte, 8)
+	if _, err := unix.Read(p.wakeFd, bs); err != nil {
+		t.Fatal(err)
+	}
+	if count := *(*uint64)(unsafe.Pointer(&bs[0])); count != wakes {
+		t.Fatalf("eventfd counter is %d, want %d", count, wakes)
+	}
+}
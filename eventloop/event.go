@@ -1,65 +1,398 @@
 package eventloop
 
 import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
 	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
 )
 
+//maxBatchSize 批量投递时一个Batch最多包含的消息数量
+const maxBatchSize = 64
+
 type EventLoop struct {
-	Num     int       // 数量
-	pollers []*Poller // 所以的poller
+	Num           int                   // 数量
+	EmitPolicy    common.EmitPolicy     // 消息队列已满时的处理方式
+	Logger        iface.ILogger         // 日志
+	EdgeTriggered bool                  // 是否使用边缘触发
+	BatchDispatch bool                  // 一次读取中解出的多个消息合并为一个util.Batch投递
+	WaitTimeout   time.Duration         // 每次wait的超时时间，<= 0 表示一直阻塞
+	OnError       iface.ErrorFunc       // 连接出现读写错误，关闭之前回调
+	pollers       []*Poller             // 所以的poller
+	connectMgr    iface.IConnectManager // 所有的连接
+	balancer      iface.ILoopBalancer   // 新连接分配策略
+	started       bool                  // 是否已调用Start
+}
+
+//LoopStat 单个事件循环的负载
+type LoopStat struct {
+	Index       int // 第几个事件循环
+	Epfd        int // epoll/kqueue的fd
+	Connections int // 当前管理的连接数量
 }
 
-func NewEventLoop(num int) *EventLoop {
+//NewEventLoop 创建事件循环，logger为服务使用的日志，事件循环内部的日志都通过它输出
+func NewEventLoop(num int, balancer iface.ILoopBalancer, logger iface.ILogger) *EventLoop {
+	if balancer == nil {
+		balancer = RoundRobin()
+	}
 	return &EventLoop{
-		Num:     num,
-		pollers: make([]*Poller, num),
+		Num:      num,
+		pollers:  make([]*Poller, num),
+		balancer: balancer,
+		Logger:   logger,
 	}
 }
 
 //Init 初始化poller
 func (e *EventLoop) Init(connectMgr iface.IConnectManager) error {
 
+	e.connectMgr = connectMgr
+
 	for i := 0; i < e.Num; i++ {
-		poller, err := NewPoller(connectMgr)
+		poller, err := NewPoller(connectMgr, e.Logger)
 		if err != nil {
+			// 关闭已经创建的poller
+			for _, created := range e.pollers[:i] {
+				created.closeWake()
+				_ = created.Close()
+			}
 			return err
 		}
 		e.pollers[i] = poller
+
+		// Stop时立即唤醒wait，不需要等待超时
+		if err := poller.initWake(); err != nil {
+			for _, created := range e.pollers[:i+1] {
+				created.closeWake()
+				_ = created.Close()
+			}
+			return err
+		}
 	}
 	return nil
 }
 
 //Start 执行epoll_wait
-func (e *EventLoop) Start(emitCh chan<- iface.IContext) {
+func (e *EventLoop) Start(emitCh chan iface.IContext) {
+	e.started = true
 	for _, poller := range e.pollers {
+		poller.emitPolicy = e.EmitPolicy
+		poller.edgeTriggered = e.EdgeTriggered
+		poller.batchDispatch = e.BatchDispatch
+		poller.waitTimeout = e.WaitTimeout
+		poller.onError = e.OnError
 		go poller.Wait(emitCh)
 	}
 }
 
-//Stop 关闭epoll
+//Stop 关闭epoll，先标记为已停止并唤醒wait，wait返回后直接退出，阻塞在emit中的发送也会返回，重复调用不会有影响
 func (e *EventLoop) Stop() {
 	for _, poller := range e.pollers {
+		if !poller.stop() {
+			continue
+		}
+
+		// 未调用过Start，没有wait负责关闭eventfd
+		if !e.started {
+			poller.closeWake()
+		}
 		_ = poller.Close()
 	}
 }
 
 //AddRead 添加读事件
 func (e *EventLoop) AddRead(conn iface.IConnect) error {
-	idx := conn.GetID() % e.Num
-	poller := e.pollers[idx]
-	if err := poller.AddRead(conn.GetFd(), conn.GetID()); err != nil {
+
+	// 还未分配事件循环
+	poller, ok := conn.GetPoller().(*Poller)
+	if !ok {
+		e.Attach(conn)
+		poller = conn.GetPoller().(*Poller)
+	}
+
+	if err := poller.AddRead(conn.GetFd(), int(conn.GetID())); err != nil {
 		return err
 	}
+	atomic.AddInt32(&poller.conns, 1)
+	return nil
+}
+
+//Attach 通过分配策略选择事件循环，设置到连接上，还不会注册任何事件，之后调用AddRead时才会开始读取
+//连接在注册事件之前就可以发送数据、加入管理，事件循环不会在这之前收到这个连接的事件
+func (e *EventLoop) Attach(conn iface.IConnect) {
+	loads := make([]int, len(e.pollers))
+	for i, poller := range e.pollers {
+		loads[i] = poller.Connections()
+	}
+	idx := e.balancer.Select(conn, loads)
+	if idx < 0 || idx >= len(e.pollers) {
+		idx = int(conn.GetID() % uint64(e.Num))
+	}
 
+	poller := e.pollers[idx]
 	connVariant := conn.(iface.IConnectEvent)
 	connVariant.SetEpFd(poller.Epfd)
 	connVariant.SetPoller(poller)
-	return nil
 }
 
 //Remove 删除某个连接
 func (e *EventLoop) Remove(conn iface.IConnect) error {
-	idx := conn.GetID() % e.Num
-	poller := e.pollers[idx]
-	return poller.Remove(conn.GetFd())
+	for _, poller := range e.pollers {
+		if poller.Epfd == conn.GetEpFd() {
+			return poller.Remove(conn.GetFd())
+		}
+	}
+	return nil
+}
+
+//Stats 获取每个事件循环当前管理的连接数量
+func (e *EventLoop) Stats() []LoopStat {
+
+	stats := make([]LoopStat, len(e.pollers))
+	index := make(map[int]int, len(e.pollers))
+	for i, poller := range e.pollers {
+		stats[i] = LoopStat{Index: i}
+		if poller != nil {
+			stats[i].Epfd = poller.Epfd
+			index[poller.Epfd] = i
+		}
+	}
+
+	if e.connectMgr == nil {
+		return stats
+	}
+
+	for _, connect := range e.connectMgr.GetConnects() {
+		if i, ok := index[connect.GetEpFd()]; ok {
+			stats[i].Connections += 1
+		}
+	}
+	return stats
+}
+
+//Connections 当前管理的连接数量
+func (p *Poller) Connections() int {
+	return int(atomic.LoadInt32(&p.conns))
+}
+
+//emit 将消息投递到队列中，队列已满时按emitPolicy处理
+func (p *Poller) emit(emitCh chan iface.IContext, ctx iface.IContext) {
+
+	// 已停止时消费者可能已经退出，不能一直阻塞
+	if p.emitPolicy == common.EmitBlock {
+		select {
+		case emitCh <- ctx:
+		case <-p.done:
+		}
+		return
+	}
+
+	for {
+		select {
+		case emitCh <- ctx:
+			return
+		default:
+		}
+
+		// 丢弃并关闭连接
+		if p.emitPolicy == common.EmitRejectClose {
+			p.logger.Warnf("message queue is full, close connID[%d]", ctx.GetConnect().GetID())
+			if event, ok := ctx.GetConnect().(iface.IConnectEvent); ok {
+				event.SetCloseReason(common.CloseRejected)
+			}
+			_ = ctx.GetConnect().Close()
+			return
+		}
+
+		// 丢弃最早的消息
+		select {
+		case oldest := <-emitCh:
+			// Shutdown的结束标记不能丢弃，重新放回队列
+			if oldest == nil {
+				select {
+				case emitCh <- nil:
+				case <-p.done:
+					return
+				}
+				continue
+			}
+			p.logger.Warnf("message queue is full, drop requestID[%d] msgID[%d] of connID[%d]", oldest.GetRequest().ID(), oldest.GetMessage().ID(), oldest.GetConnect().GetID())
+		default:
+		}
+	}
+}
+
+//read 处理可读事件，水平触发时每次事件只读取一个包，边缘触发时需要一直读取到EAGAIN，否则剩余的数据不会再通知
+//批量投递时水平触发也会一直读取，最多解出maxBatchSize个包后投递一次
+//peerClosed表示对端已关闭写端(EPOLLRDHUP/EV_EOF)，之后不会再有新的数据，一直读取到EOF后立即关闭连接
+func (p *Poller) read(conn iface.IConnect, connEvent iface.IConnectEvent, emitCh chan iface.IContext, peerClosed bool) {
+
+	var batch []iface.IContext
+	for {
+		// 2、非阻塞模式读取一个完整的包
+		message, err := connEvent.DecodePacket()
+		if err != nil {
+
+			// 之前解出的消息先投递出去，和逐个投递时的顺序一致
+			p.flush(emitCh, batch)
+
+			switch err {
+			case io.EOF:
+				// 对端正常关闭
+				connEvent.SetCloseReason(common.ClosePeer)
+				_ = conn.Close()
+			case util.HeadBytesLengthFail, util.BodyLenExceedLimit, util.DecompressFail, util.DecryptFail, util.CryptoKeyNotFound, util.MagicMismatch, util.VersionMismatch:
+				// 断开连接
+				p.fail(conn, common.CloseReadError, err)
+				_ = conn.Close()
+			case util.ProxyHeaderInvalid, util.ProxyAddressRejected:
+				// 未发送PROXY protocol头部或真实地址不允许连接
+				p.fail(conn, common.CloseRejected, err)
+				_ = conn.Close()
+			case
+				util.WebsocketOpcodeFail,
+				util.WebsocketRsvFail,
+				util.WebsocketCtrlMessageMustNotFragmented,
+				util.WebsocketProtocolError,
+				util.WebsocketPingPayloadOversize,
+				util.WebsocketPacketIncomplete:
+				p.fail(conn, common.CloseReadError, err)
+				_ = conn.(iface.IWebsocketCloser).CloseCode(1002, "protocol error.")
+			case util.WebsocketMustUtf8:
+				p.fail(conn, common.CloseReadError, err)
+				_ = conn.(iface.IWebsocketCloser).CloseCode(1007, "non-UTF-8 data within a text message")
+			default:
+				// 读取出错，如：ECONNRESET，不再等待之后的EOF
+				if errno, ok := err.(unix.Errno); ok && errno != unix.EAGAIN && errno != unix.EINTR {
+					p.fail(conn, common.CloseReadError, err)
+					_ = conn.Close()
+				}
+			}
+
+			// 可能是 unix.EAGAIN，数据已读取完毕
+			return
+		}
+
+		// 3、将消息传递出去，交给worker处理（websocket是可以发送payload长度为0的消息）
+		if message != nil && (message.Len() > 0 || message.IsWebsocket()) {
+			context := util.NewContext(util.NewRequest(conn, message, p.ConnectMgr))
+			if p.batchDispatch {
+				batch = append(batch, context)
+			} else {
+				p.emit(emitCh, context)
+			}
+		}
+
+		if p.batchDispatch {
+			if len(batch) >= maxBatchSize {
+				p.flush(emitCh, batch)
+				batch = nil
+
+				// 水平触发时剩余的数据会再次通知，避免一直读取同一个连接
+				if !p.edgeTriggered && !peerClosed {
+					return
+				}
+			}
+		} else if !p.edgeTriggered && !peerClosed {
+			return
+		}
+
+		// 连接已在处理过程中关闭，fd可能已经被新连接复用，不能继续读取
+		if p.ConnectMgr.Get(conn.GetFd()) != conn {
+			p.flush(emitCh, batch)
+			return
+		}
+	}
+}
+
+//flush 投递批量读取到的消息，只有一个时和逐个投递一样直接投递
+func (p *Poller) flush(emitCh chan iface.IContext, batch []iface.IContext) {
+	switch len(batch) {
+	case 0:
+	case 1:
+		p.emit(emitCh, batch[0])
+	default:
+		p.emit(emitCh, util.NewBatch(batch))
+	}
+}
+
+//stop 标记为已停止，并唤醒wait，已经停止过时返回false
+func (p *Poller) stop() bool {
+	p.taskLock.Lock()
+	defer p.taskLock.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&p.stopped, 0, 1) {
+		return false
+	}
+	close(p.done)
+	p.tasks = nil
+	p.wake()
+	return true
+}
+
+//Submit 提交一个任务，唤醒事件循环后在事件循环的协程中执行，任务中不能有阻塞的操作
+//已停止时返回util.PollerStopped
+func (p *Poller) Submit(task func()) error {
+	p.taskLock.Lock()
+	defer p.taskLock.Unlock()
+
+	if p.isStopped() {
+		return util.PollerStopped
+	}
+
+	// 已有等待执行的任务时已经唤醒过，不需要重复唤醒
+	p.tasks = append(p.tasks, task)
+	if len(p.tasks) == 1 {
+		p.wake()
+	}
+	return nil
+}
+
+//runTasks 执行Submit提交的任务
+func (p *Poller) runTasks() {
+	p.taskLock.Lock()
+	tasks := p.tasks
+	p.tasks = nil
+	p.taskLock.Unlock()
+
+	for _, task := range tasks {
+		task()
+	}
+}
+
+//isStopped 是否已停止，wait超时或返回错误时检查
+func (p *Poller) isStopped() bool {
+	return atomic.LoadInt32(&p.stopped) == 1
+}
+
+//hangup 暂停读取期间对端关闭或连接出错，内核缓冲区中未读取的数据会被丢弃
+func (p *Poller) hangup(conn iface.IConnect, connEvent iface.IConnectEvent) {
+	if errno, err := unix.GetsockoptInt(conn.GetFd(), unix.SOL_SOCKET, unix.SO_ERROR); err == nil && errno != 0 {
+		p.fail(conn, common.CloseReadError, unix.Errno(errno))
+	} else {
+		connEvent.SetCloseReason(common.ClosePeer)
+	}
+	_ = conn.Close()
+}
+
+//fail 连接出现读写错误，关闭之前记录错误、原因并回调OnError，系统调用的错误会带上操作、连接ID和fd
+func (p *Poller) fail(conn iface.IConnect, reason common.CloseReason, err error) {
+	op := util.OpRead
+	if reason == common.CloseWriteError {
+		op = util.OpWrite
+	}
+	err = util.WrapSyscallError(op, conn.GetID(), conn.GetFd(), err)
+
+	if event, ok := conn.(iface.IConnectEvent); ok {
+		event.SetCloseError(err)
+		event.SetCloseReason(reason)
+	}
+	if p.onError != nil {
+		p.onError(conn, err)
+	}
 }
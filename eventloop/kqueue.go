@@ -3,40 +3,68 @@
 package eventloop
 
 import (
-	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ikilobyte/netman/common"
 	"github.com/ikilobyte/netman/iface"
 	"github.com/ikilobyte/netman/util"
 	"golang.org/x/sys/unix"
 )
 
 type Poller struct {
-	Epfd       int                   // eventpoll fd
-	Events     []unix.Kevent_t       //
-	ConnectMgr iface.IConnectManager //
+	Epfd          int                   // eventpoll fd
+	Events        []unix.Kevent_t       //
+	ConnectMgr    iface.IConnectManager //
+	conns         int32                 // 当前管理的连接数量
+	logger        iface.ILogger         // 日志
+	emitPolicy    common.EmitPolicy     // 消息队列已满时的处理方式
+	edgeTriggered bool                  // 是否为边缘触发
+	batchDispatch bool                  // 是否批量投递消息
+	waitTimeout   time.Duration         // wait的超时时间，<= 0 表示一直阻塞
+	stopped       int32                 // 是否已停止
+	wakeFd        int                   // 用来唤醒wait的eventfd，-1表示未创建
+	tasks         []func()              // Submit提交的任务，被唤醒后在事件循环中执行
+	taskLock      sync.Mutex            // 保护tasks、wakeFd
+	onError       iface.ErrorFunc       // 连接出现读写错误时回调
+	done          chan struct{}         // 停止时关闭，阻塞在emit中的发送会返回
 }
 
 //NewPoller 创建kqueue
-func NewPoller(connectMgr iface.IConnectManager) (*Poller, error) {
+func NewPoller(connectMgr iface.IConnectManager, logger iface.ILogger) (*Poller, error) {
 
 	fd, err := unix.Kqueue()
 	if err != nil {
 		return nil, err
 	}
+	unix.CloseOnExec(fd)
 
 	return &Poller{
 		Epfd:       fd,
 		Events:     make([]unix.Kevent_t, 128),
 		ConnectMgr: connectMgr,
+		logger:     logger,
+		wakeFd:     -1,
+		done:       make(chan struct{}),
 	}, nil
 }
 
+//timespec kevent的超时时间，nil表示一直阻塞
+func (p *Poller) timespec() *unix.Timespec {
+	if p.waitTimeout <= 0 {
+		return nil
+	}
+	ts := unix.NsecToTimespec(int64(p.waitTimeout))
+	return &ts
+}
+
 func (p *Poller) AddRead(fd int, connID int) error {
 	_, err := unix.Kevent(p.Epfd, []unix.Kevent_t{
 		{
 			Ident:  uint64(fd),
 			Filter: unix.EVFILT_READ,
-			Flags:  unix.EV_ADD,
+			Flags:  unix.EV_ADD | p.trigger(),
 			Fflags: 0,
 			Data:   int64(connID),
 			Udata:  nil,
@@ -50,7 +78,7 @@ func (p *Poller) AddWrite(fd, connID int) error {
 		{
 			Ident:  uint64(fd),
 			Filter: unix.EVFILT_WRITE,
-			Flags:  unix.EV_ADD,
+			Flags:  unix.EV_ADD | p.trigger(),
 			Fflags: 0,
 			Data:   int64(connID),
 			Udata:  nil,
@@ -104,18 +132,52 @@ func (p *Poller) ModRead(fd, connID int) error {
 	return p.AddRead(fd, connID)
 }
 
+//PauseRead 禁用读事件，不删除，ModWrite时仍然可以正常删除读事件
+func (p *Poller) PauseRead(fd, connID int) error {
+	_, err := unix.Kevent(p.Epfd, []unix.Kevent_t{
+		{
+			Ident:  uint64(fd),
+			Filter: unix.EVFILT_READ,
+			Flags:  unix.EV_DISABLE,
+			Data:   int64(connID),
+		},
+	}, nil, nil)
+	return err
+}
+
+//ResumeRead 恢复读事件
+func (p *Poller) ResumeRead(fd, connID int) error {
+	_, err := unix.Kevent(p.Epfd, []unix.Kevent_t{
+		{
+			Ident:  uint64(fd),
+			Filter: unix.EVFILT_READ,
+			Flags:  unix.EV_ENABLE,
+			Data:   int64(connID),
+		},
+	}, nil, nil)
+	return err
+}
+
 //Wait 这里处理的是socket的读
-func (p *Poller) Wait(emitCh chan<- iface.IContext) {
+func (p *Poller) Wait(emitCh chan iface.IContext) {
+
+	defer p.closeWake()
 
 	for {
 
-		n, err := unix.Kevent(p.Epfd, nil, p.Events, nil)
+		n, err := unix.Kevent(p.Epfd, nil, p.Events, p.timespec())
+
+		// 已停止，kqueue已关闭或即将关闭
+		if p.isStopped() {
+			return
+		}
+
 		if err != nil {
 			if err == unix.EINTR || err == unix.EAGAIN {
 				continue
 			}
 
-			util.Logger.WithField("epfd", p.Epfd).WithField("error", err).Error("kqueue wait error")
+			p.logger.WithFields(map[string]interface{}{"epfd": p.Epfd, "error": err}).Errorf("kqueue wait error")
 
 			// 断开这个epoll管理的所有连接
 			p.ConnectMgr.ClearByEpFd(p.Epfd)
@@ -132,6 +194,12 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 				connEvent iface.IConnectEvent
 			)
 
+			// 被Stop、Submit唤醒
+			if event.Filter == unix.EVFILT_USER {
+				p.runTasks()
+				continue
+			}
+
 			// 1、通过connID获取conn实例
 			if conn = p.ConnectMgr.Get(connFd); conn == nil {
 				// 断开连接
@@ -146,8 +214,10 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 			if event.Filter == unix.EVFILT_WRITE {
 				if err := connEvent.ProceedWrite(); err != nil {
 					// 断开连接
+					err = util.WrapSyscallError(util.OpWrite, conn.GetID(), connFd, err)
+					p.fail(conn, common.CloseWriteError, err)
 					_ = conn.Close()
-					util.Logger.Errorf("kqueue proceed write error %v", err)
+					p.logger.Errorf("kqueue proceed write error %v", err)
 					continue
 				}
 				continue
@@ -159,8 +229,9 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 				tlsLayer := conn.GetTLSLayer()
 				if err := tlsLayer.Handshake(); err != nil {
 					// 断开连接
+					p.fail(conn, common.CloseReadError, err)
 					_ = conn.Close()
-					util.Logger.Errorf("tls handshake error %v", err)
+					p.logger.Errorf("tls handshake error %v", err)
 					continue
 				}
 				// 1、设置状态
@@ -173,49 +244,78 @@ func (p *Poller) Wait(emitCh chan<- iface.IContext) {
 				}
 			}
 
-			// 2、读取一个完整的包
-			message, err := connEvent.DecodePacket()
-			if err != nil {
-
-				switch err {
-				case io.EOF, util.HeadBytesLengthFail, util.BodyLenExceedLimit:
-					// 断开连接操作
-					_ = conn.Close()
-				case
-					util.WebsocketOpcodeFail,
-					util.WebsocketRsvFail,
-					util.WebsocketCtrlMessageMustNotFragmented,
-					util.WebsocketProtocolError,
-					util.WebsocketPingPayloadOversize:
-					_ = conn.(iface.IWebsocketCloser).CloseCode(1002, "protocol error.")
-				case util.WebsocketMustUtf8:
-					_ = conn.(iface.IWebsocketCloser).CloseCode(1007, "non-UTF-8 data within a text message")
-				default:
-					continue
-				}
-			}
-
-			if message == nil {
-				continue
-			}
-
-			// 3、将消息传递出去，交给worker处理（websocket是可以发送payload长度为0的消息）
-			if message.Len() <= 0 && message.IsWebsocket() == false {
-				continue
-			}
-			emitCh <- util.NewContext(util.NewRequest(conn, message, p.ConnectMgr))
+			// 2、读取数据，对端已关闭写端(EV_EOF)时一直读取到EOF
+			p.read(conn, connEvent, emitCh, event.Flags&unix.EV_EOF != 0)
 		}
 	}
 }
 
+//trigger 边缘触发时需要加上EV_CLEAR，事件返回后重置状态
+func (p *Poller) trigger() uint16 {
+	if p.edgeTriggered {
+		return unix.EV_CLEAR
+	}
+	return 0
+}
+
+//Remove 删除fd的读写事件，和epoll一样只有删除成功时才减少连接数量
+//未注册过的fd（如：事件循环中找不到连接时已经关闭的fd、Attach后还未AddRead就关闭的连接）不会计入
 func (p *Poller) Remove(fd int) error {
+	readErr := p.delete(fd, unix.EVFILT_READ)
+	writeErr := p.delete(fd, unix.EVFILT_WRITE)
+	if readErr != nil && writeErr != nil {
+		return readErr
+	}
+	atomic.AddInt32(&p.conns, -1)
 	return nil
 }
 
+//delete 删除fd的一个事件，未注册过时返回ENOENT，fd已关闭时返回EBADF
+func (p *Poller) delete(fd int, filter int16) error {
+	_, err := unix.Kevent(p.Epfd, []unix.Kevent_t{
+		{
+			Ident:  uint64(fd),
+			Filter: filter,
+			Flags:  unix.EV_DELETE,
+		},
+	}, nil, nil)
+	return err
+}
+
 func (p *Poller) Close() error {
 	return unix.Close(p.Epfd)
 }
 
+//closeWake wait退出后不再唤醒
+func (p *Poller) closeWake() {
+	p.taskLock.Lock()
+	defer p.taskLock.Unlock()
+	p.wakeFd = -1
+}
+
+//initWake 添加EVFILT_USER事件，触发后kevent会立即返回
+func (p *Poller) initWake() error {
+	if _, err := unix.Kevent(p.Epfd, []unix.Kevent_t{
+		{Ident: 0, Filter: unix.EVFILT_USER, Flags: unix.EV_ADD | unix.EV_CLEAR},
+	}, nil, nil); err != nil {
+		return err
+	}
+
+	// EVFILT_USER不需要fd，ident固定为0
+	p.wakeFd = 0
+	return nil
+}
+
+//wake 唤醒kevent，调用方需要持有taskLock
+func (p *Poller) wake() {
+	if p.wakeFd < 0 {
+		return
+	}
+	_, _ = unix.Kevent(p.Epfd, []unix.Kevent_t{
+		{Ident: 0, Filter: unix.EVFILT_USER, Fflags: unix.NOTE_TRIGGER},
+	}, nil, nil)
+}
+
 //GetConnectMgr .
 func (p *Poller) GetConnectMgr() iface.IConnectManager {
 	return p.ConnectMgr
@@ -86,7 +86,7 @@ func demo() iface.MiddlewareFunc {
 	}
 }
 
-var loginStore map[int]time.Time
+var loginStore map[uint64]time.Time
 
 //authentication 分组中间件
 func authentication() iface.MiddlewareFunc {
@@ -116,7 +116,7 @@ func main() {
 		6565,
 		server.WithNumEventLoop(runtime.NumCPU()*3),
 		server.WithHooks(new(Hooks)),            // hook
-		server.WithMaxBodyLength(0),             // 配置包体最大长度，默认为0（不限制大小）
+		server.WithMaxBodyLength(0),             // 配置包体最大长度，默认为16MB，0表示不限制大小
 		server.WithTCPKeepAlive(time.Second*30), // 设置TCPKeepAlive
 		server.WithLogOutput(os.Stdout),         // 框架运行日志保存的地方
 		//server.WithPacker() // 可自行实现数据封包解包
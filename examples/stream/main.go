@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/server"
+)
+
+const (
+	downloadMsgID = 1 // 请求下载，包体为文件路径
+	chunkMsgID    = 2 // 文件分块
+	endMsgID      = 3 // 文件发送完毕
+)
+
+//DownloadRouter 一个请求对应多次响应，在路由中分块推送文件内容
+type DownloadRouter struct{}
+
+func (d *DownloadRouter) Do(request iface.IRequest) {
+
+	connect := request.GetConnect()
+	file, err := os.Open(string(request.GetMessage().Bytes()))
+	if err != nil {
+		_, _ = connect.Send(endMsgID, []byte(err.Error()))
+		return
+	}
+	defer file.Close()
+
+	chunk := make([]byte, 1024*32)
+	for {
+		n, err := file.Read(chunk)
+		if n > 0 {
+			// Send可以在路由中多次调用，对端暂时无法接收时会进入写入队列，按顺序发送
+			if _, err := connect.Send(chunkMsgID, chunk[:n]); err != nil {
+				fmt.Println("send chunk err", err)
+				return
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			_, _ = connect.Send(endMsgID, []byte(err.Error()))
+			return
+		}
+	}
+
+	_, _ = connect.Send(endMsgID, nil)
+}
+
+func main() {
+	s := server.New("0.0.0.0", 6565)
+	s.AddRouter(downloadMsgID, new(DownloadRouter))
+	s.Start()
+}
@@ -1,8 +1,16 @@
 package iface
 
+import "net"
+
+//AcceptFunc 新连接加入事件循环之前回调，返回error时关闭这个连接
+type AcceptFunc = func(fd int, addr net.Addr) error
+
 type IAcceptor interface {
 	Run(fd int, loop IEventLoop) error
 	Exit()
-	IncrementID() int
+	IncrementID() uint64
 	Close()
+	Pause() error             // 暂停接收新连接
+	Resume() error            // 恢复接收新连接
+	AddListener(fd int) error // 添加一个监听的fd
 }
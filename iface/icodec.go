@@ -0,0 +1,12 @@
+package iface
+
+//ICodec 包体编解码，IPacker同时实现这个接口后，可以使用IRequest.Unmarshal解码包体
+type ICodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+//IValidator IRequest.Bind解码之后会调用Validate校验
+type IValidator interface {
+	Validate() error
+}
@@ -0,0 +1,7 @@
+package iface
+
+//ICompressor 包体压缩，默认提供了gzip、snappy，实现这个接口可以接入zstd等
+type ICompressor interface {
+	Compress(data []byte) ([]byte, error)                  // 压缩
+	Decompress(data []byte, maxLength int) ([]byte, error) // 解压，解压后超过maxLength时返回util.BodyLenExceedLimit，0表示不限制
+}
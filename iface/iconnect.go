@@ -1,6 +1,7 @@
 package iface
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/url"
@@ -9,19 +10,30 @@ import (
 	"github.com/ikilobyte/netman/common"
 )
 
+//ErrorFunc 连接出现读写错误时的回调
+type ErrorFunc = func(connect IConnect, err error)
+
+//SlowClientFunc 连接的写入积压持续超过阈值时的回调，backlog为当前积压的字节数
+type SlowClientFunc = func(connect IConnect, backlog int)
+
 type IConnect interface {
 	Read(bs []byte) (int, error)
 	GetFd() int
-	GetID() int
+	GetID() uint64 // 进程内唯一，单调递增，不会复用，配置了IDGenerator时由其生成
 	Close() error
 	GetPacker() IPacker
+	SetPacker(packer IPacker) // 之后的读写使用这个连接单独的封包方式，nil表示恢复默认
 	Send(msgID uint32, bs []byte) (int, error)
 	GetAddress() net.Addr
+	RemoteAddr() net.Addr
+	LocalAddr() net.Addr
 	GetEpFd() int
 	GetPoller() IPoller
 	GetWriteBuff() ([]byte, bool)
 	SetLastMessageTime(lastMessageTime time.Time)
 	GetLastMessageTime() time.Time
+	ConnectedAt() time.Time  // 建立连接的时间
+	LastActiveAt() time.Time // 最后一次收到数据的时间
 	GetTLSEnable() bool
 	GetHandshakeCompleted() bool
 	SetHandshakeCompleted()
@@ -31,6 +43,32 @@ type IConnect interface {
 	Text([]byte) (int, error)        // 发送websocket text数据
 	Binary([]byte) (int, error)      // 发送 websocket 二进制格式数据
 	GetQueryStringParam() url.Values // 仅在websocket时可用
+	SetProperty(key string, value interface{})
+	GetProperty(key string) (interface{}, error)
+	RemoveProperty(key string)
+	SetWriteDeadline(t time.Time) error
+	Context() context.Context // 连接关闭或服务关闭时取消
+	AsyncSend(msgID uint32, bs []byte) error
+	SendNoFlush(msgID uint32, bs []byte) error // 先缓存，调用Flush时合并发送
+	Flush() error
+	CloseWrite() error        // 只关闭写端，仍然可以读取
+	AddTag(key, value string) // 连接关闭后自动清除
+	RemoveTag(key string)
+	GetTag(key string) (string, bool)
+	HasTag(key, value string) bool
+	NextSeq() uint64                             // 连接上递增的序列号，从1开始
+	CloseGracefully(timeout time.Duration) error // 发送完待发送的数据后再关闭
+	CloseError() error                           // 导致连接关闭的错误，正常关闭时为nil
+	CloseReason() common.CloseReason             // 连接关闭的原因，OnClose中可用
+	PauseRead() error                            // 暂停读取，数据保留在内核缓冲区中
+	ResumeRead() error                           // 恢复读取
+	ReadPaused() bool                            // 是否调用了PauseRead
+	PendingBytes() int                           // 等待可写后再发送的字节数
+	SendRaw(data []byte) error                   // 不经过封包直接发送，调用方保证数据格式正确
+	Go(task func()) error                        // 在连接专属的协程中按提交顺序执行
+	BytesRead() uint64                           // 这个连接累计读取的字节数
+	BytesWritten() uint64                        // 这个连接累计写入内核的字节数
+	WriteBacklog() int                           // 已发送但还未写入内核的字节数，PendingBytes加上AsyncSend队列中的字节数
 }
 
 //IConnectEvent 专门处理epoll/kqueue事件的方法，无需对外提供
@@ -41,6 +79,8 @@ type IConnectEvent interface {
 	SetWriteBuff([]byte)
 	SetEpFd(epfd int)
 	SetPoller(poller IPoller)
+	SetCloseError(err error)
+	SetCloseReason(reason common.CloseReason)
 }
 
 type IWebsocketCloser interface {
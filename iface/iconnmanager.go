@@ -2,10 +2,13 @@ package iface
 
 type IConnectManager interface {
 	Get(connFD int) IConnect
+	GetByID(connID uint64) (IConnect, bool)
 	Add(conn IConnect) int
 	GetConnects() []IConnect
+	Range(callable func(conn IConnect) bool)
 	Remove(conn IConnect)
 	Len() int
+	CountByIP(ip string) int
 	ClearByEpFd(epfd int)
 	ClearAll()
 	HeartbeatCheck()
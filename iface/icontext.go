@@ -4,6 +4,7 @@ type IContext interface {
 	GetRequest() IRequest
 	GetConnect() IConnect
 	GetMessage() IMessage
+	GetResponse() IResponse
 	Set(key, value interface{})
 	Get(key interface{}) interface{}
 }
@@ -0,0 +1,10 @@
+package iface
+
+//ICrypto 包体加解密，默认提供了AES-GCM，加密后的长度最多只能增加CryptoMaxOverhead个字节
+type ICrypto interface {
+	Encrypt(data []byte) ([]byte, error) // 加密
+	Decrypt(data []byte) ([]byte, error) // 解密，数据被篡改或密钥不匹配时返回错误
+}
+
+//CryptoMaxOverhead 加密后包体最多增加的长度，接收时包体最大长度会放宽这么多
+const CryptoMaxOverhead = 256
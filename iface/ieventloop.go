@@ -3,8 +3,14 @@ package iface
 //IEventLoop 事件循环抽象层，所有的epoll都是通过这个来操作
 type IEventLoop interface {
 	Init(connectMgr IConnectManager) error // 初始化，也就是创建epoll
-	Start(messageCh chan<- IContext)       // 开启事件循环，也就是所有的epoll执行epoll_wait
+	Start(messageCh chan IContext)         // 开启事件循环，也就是所有的epoll执行epoll_wait
 	Stop()                                 // 停止
-	AddRead(conn IConnect) error
+	Attach(conn IConnect)                  // 分配事件循环，还不注册事件
+	AddRead(conn IConnect) error           // 注册读事件，未调用Attach时会先分配
 	Remove(conn IConnect) error
 }
+
+//ILoopBalancer 新连接分配到哪个事件循环，loads为每个事件循环当前管理的连接数量，返回事件循环的下标
+type ILoopBalancer interface {
+	Select(conn IConnect, loads []int) int
+}
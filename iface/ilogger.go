@@ -0,0 +1,10 @@
+package iface
+
+//ILogger 日志接口，默认使用logrus实现，可以接入zap、zerolog等
+type ILogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	WithFields(fields map[string]interface{}) ILogger // 返回附带了字段的日志实例，不影响原实例
+}
@@ -11,3 +11,8 @@ type IMessage interface {
 	IsText() bool
 	IsBinary() bool
 }
+
+//ISeqMessage 带有序列号的消息
+type ISeqMessage interface {
+	Seq() uint64 // 0表示未设置
+}
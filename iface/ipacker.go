@@ -1,9 +1,17 @@
 package iface
 
 //IPacker 数据封装抽象层
+//读取时框架会先读取GetHeaderLength()个字节交给UnPack解析出包体长度，
+//再按IMessage.Len()继续读取包体，非阻塞模式下包头、包体都可能会分多次可读事件读取，
+//框架会在连接上缓存未读取完整的包头和包体，UnPack收到的一定是完整的包头，实现方无需处理半包
 type IPacker interface {
 	Pack(msgID uint32, data []byte) ([]byte, error) // 封包
 	UnPack([]byte) (IMessage, error)                // 解包
 	SetMaxBodyLength(uint32)                        // 设置包体最大长度限制
 	GetHeaderLength() uint32                        // 获取头部长度
 }
+
+//ISeqPacker 头部可以写入序列号的封包方式，开启Options.Sequence时发送的数据包会带上连接的序列号
+type ISeqPacker interface {
+	PackWithSeq(msgID uint32, seq uint64, data []byte) ([]byte, error)
+}
@@ -5,7 +5,10 @@ type IPoller interface {
 	AddWrite(fd, connID int) error
 	ModWrite(fd, connID int) error
 	ModRead(fd, connId int) error
-	Wait(emitCh chan<- IContext)
+	PauseRead(fd, connID int) error  // 暂停读事件，数据会保留在内核缓冲区中
+	ResumeRead(fd, connID int) error // 恢复读事件
+	Submit(task func()) error        // 在事件循环的协程中执行任务
+	Wait(emitCh chan IContext)
 	Remove(fd int) error
 	Close() error
 	GetConnectMgr() IConnectManager
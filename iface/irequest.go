@@ -1,7 +1,15 @@
 package iface
 
+import "context"
+
 type IRequest interface {
+	ID() uint64 // 请求ID，进程内唯一
 	GetConnect() IConnect
 	GetMessage() IMessage
 	GetConnects() []IConnect
+	Unmarshal(v interface{}) error
+	Bind(v interface{}) error // 解码包体，实现了IValidator时再校验
+	Context() context.Context // 本次请求的context，默认为连接的context
+	SetContext(ctx context.Context)
+	Peek() []byte // 包体的副本，不影响之后读取完整的包体
 }
@@ -0,0 +1,18 @@
+package iface
+
+//ResponseFilter 发送前对响应进行处理，如压缩、加密，返回error时不再发送
+type ResponseFilter = func(msgID uint32, data []byte) (uint32, []byte, error)
+
+//IResponse 统一的响应方式，中间件可以通过Filter检查、修改响应
+type IResponse interface {
+	Send(msgID uint32, data []byte) error // 发送指定msgID的响应
+	Reply(data []byte) error              // 使用请求的msgID响应
+	SendError(code int, msg string) error // 使用请求的msgID响应错误信息，包体为json：{"code":0,"msg":""}
+	Filter(filter ResponseFilter)         // 添加发送前的处理，按添加的顺序执行
+	GetConnect() IConnect                 // 原始连接，用于更复杂的场景
+}
+
+//IResponseRouter 带响应对象的路由
+type IResponseRouter interface {
+	DoResponse(request IRequest, response IResponse)
+}
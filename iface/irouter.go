@@ -1,6 +1,16 @@
 package iface
 
+import "context"
+
 //IRouter 路由抽象，根据业务场景实现这个接口即可，通过msgID和router对应
 type IRouter interface {
 	Do(request IRequest)
 }
+
+//IContextRouter 带context的路由，连接关闭或服务关闭时ctx会被取消
+type IContextRouter interface {
+	DoContext(ctx context.Context, request IRequest)
+}
+
+//PanicHandler 路由处理（包括中间件）发生panic时的回调
+type PanicHandler = func(request IRequest, recovered interface{})
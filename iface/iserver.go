@@ -2,7 +2,7 @@ package iface
 
 //IServer Server抽象层
 type IServer interface {
-	Start()
+	Start() error
 	Stop()
 	AddRouter(msgID uint32, router IRouter)
 	SetWebSocketHandler(IWebsocketHandler)
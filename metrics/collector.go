@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/ikilobyte/netman/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//StatsProvider 提供运行状态的实例，一般为*server.Server
+type StatsProvider interface {
+	Stats() server.Stats
+	RouteStats() map[uint32]server.RouteStat
+}
+
+//Collector 实现了prometheus.Collector，采集时从server的计数器中读取
+type Collector struct {
+	provider      StatsProvider
+	connections   *prometheus.Desc
+	accepted      *prometheus.Desc
+	closed        *prometheus.Desc
+	messages      *prometheus.Desc
+	bytesRead     *prometheus.Desc
+	bytesWritten  *prometheus.Desc
+	queueDepth    *prometheus.Desc
+	handleLatency *prometheus.Desc
+	handleErrors  *prometheus.Desc
+}
+
+//NewCollector 构造一个Collector，namespace为指标名称前缀，为空时使用netman
+func NewCollector(provider StatsProvider, namespace string) *Collector {
+
+	if namespace == "" {
+		namespace = "netman"
+	}
+
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+
+	return &Collector{
+		provider:      provider,
+		connections:   desc("connections", "Current number of connections."),
+		accepted:      desc("connections_accepted_total", "Total number of accepted connections."),
+		closed:        desc("connections_closed_total", "Total number of closed connections."),
+		messages:      desc("messages_total", "Total number of processed messages."),
+		bytesRead:     desc("read_bytes_total", "Total number of bytes read."),
+		bytesWritten:  desc("written_bytes_total", "Total number of bytes written."),
+		queueDepth:    desc("queue_depth", "Number of messages waiting to be dispatched."),
+		handleLatency: desc("handle_duration_seconds", "Handler latency by message ID.", "msg_id"),
+		handleErrors:  desc("handle_errors_total", "Total number of failed handlers by message ID.", "msg_id"),
+	}
+}
+
+//Register 注册到指定的registry
+func (c *Collector) Register(registerer prometheus.Registerer) error {
+	return registerer.Register(c)
+}
+
+//Describe 实现prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.accepted
+	ch <- c.closed
+	ch <- c.messages
+	ch <- c.bytesRead
+	ch <- c.bytesWritten
+	ch <- c.queueDepth
+	ch <- c.handleLatency
+	ch <- c.handleErrors
+}
+
+//Collect 实现prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.provider.Stats()
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.Connections))
+	ch <- prometheus.MustNewConstMetric(c.accepted, prometheus.CounterValue, float64(stats.TotalAccepted))
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.TotalClosed))
+	ch <- prometheus.MustNewConstMetric(c.messages, prometheus.CounterValue, float64(stats.TotalMessages))
+	ch <- prometheus.MustNewConstMetric(c.bytesRead, prometheus.CounterValue, float64(stats.BytesRead))
+	ch <- prometheus.MustNewConstMetric(c.bytesWritten, prometheus.CounterValue, float64(stats.BytesWritten))
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(stats.QueueDepth))
+
+	// 路由耗时使用server内部的统计，不需要额外注册中间件
+	for msgID, stat := range c.provider.RouteStats() {
+		label := strconv.FormatUint(uint64(msgID), 10)
+		ch <- prometheus.MustNewConstHistogram(c.handleLatency, stat.Count, stat.Total.Seconds(), histogramBuckets(stat), label)
+		ch <- prometheus.MustNewConstMetric(c.handleErrors, prometheus.CounterValue, float64(stat.Errors), label)
+	}
+}
+
+//histogramBuckets 转换为prometheus的累计桶，最后一个桶包括了更大的耗时，由+Inf(即Count)表示
+func histogramBuckets(stat server.RouteStat) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(stat.Buckets))
+	var cumulative uint64
+	for i := 0; i < len(stat.Buckets)-1; i++ {
+		cumulative += stat.Buckets[i]
+		buckets[server.RouteStatBucketBound(i).Seconds()] = cumulative
+	}
+	return buckets
+}
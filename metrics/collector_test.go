@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//fakeProvider 固定的运行状态
+type fakeProvider struct {
+	routes map[uint32]server.RouteStat
+}
+
+func (p *fakeProvider) Stats() server.Stats {
+	return server.Stats{Connections: 1}
+}
+
+func (p *fakeProvider) RouteStats() map[uint32]server.RouteStat {
+	return p.routes
+}
+
+func TestCollectRouteStats(t *testing.T) {
+	buckets := make([]uint64, 34)
+	buckets[0] = 2  // <= 1µs
+	buckets[10] = 1 // <= 1024µs
+	buckets[33] = 1 // 超过最后一个桶的上限
+	provider := &fakeProvider{routes: map[uint32]server.RouteStat{
+		7: {Count: 4, Errors: 1, Total: 3 * time.Millisecond, Buckets: buckets},
+	}}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := NewCollector(provider, "").Register(registry); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	for _, family := range families {
+		found[family.GetName()] = true
+		switch family.GetName() {
+		case "netman_handle_duration_seconds":
+			metric := family.GetMetric()[0]
+			if label := metric.GetLabel()[0]; label.GetName() != "msg_id" || label.GetValue() != "7" {
+				t.Fatalf("label %s=%s", label.GetName(), label.GetValue())
+			}
+			histogram := metric.GetHistogram()
+			if histogram.GetSampleCount() != 4 || histogram.GetSampleSum() != 0.003 {
+				t.Fatalf("count %d sum %v", histogram.GetSampleCount(), histogram.GetSampleSum())
+			}
+			for _, bucket := range histogram.GetBucket() {
+				var want uint64
+				switch {
+				case bucket.GetUpperBound() >= server.RouteStatBucketBound(10).Seconds():
+					want = 3
+				default:
+					want = 2
+				}
+				if bucket.GetCumulativeCount() != want {
+					t.Fatalf("bucket %v has %d, want %d", bucket.GetUpperBound(), bucket.GetCumulativeCount(), want)
+				}
+			}
+		case "netman_handle_errors_total":
+			if v := family.GetMetric()[0].GetCounter().GetValue(); v != 1 {
+				t.Fatalf("errors %v, want 1", v)
+			}
+		}
+	}
+	for _, name := range []string{"netman_connections", "netman_handle_duration_seconds", "netman_handle_errors_total"} {
+		if !found[name] {
+			t.Errorf("metric %s not collected", name)
+		}
+	}
+}
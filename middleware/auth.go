@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//AuthedKey 连接属性中标记已认证的key
+const AuthedKey = "authed"
+
+//AuthCheck 校验登录消息，返回true表示认证通过
+type AuthCheck = func(conn iface.IConnect, request iface.IRequest) bool
+
+//SetAuthed 标记连接已认证，可以在登录的路由中调用，效果和check返回true一样
+func SetAuthed(conn iface.IConnect) {
+	conn.SetProperty(AuthedKey, true)
+}
+
+//IsAuthed 连接是否已认证
+func IsAuthed(conn iface.IConnect) bool {
+	value, err := conn.GetProperty(AuthedKey)
+	if err != nil {
+		return false
+	}
+	authed, _ := value.(bool)
+	return authed
+}
+
+//Auth 认证中间件，连接认证之前只允许loginMsgID的消息，其他消息会被拒绝，使用原消息的msgID回复"unauthenticated"
+//check不为nil时先校验登录消息，通过后标记为已认证再执行登录的路由，不通过时同样拒绝；为nil时由登录的路由调用SetAuthed
+func Auth(loginMsgID uint32, check AuthCheck) iface.MiddlewareFunc {
+	return func(ctx iface.IContext, next iface.Next) interface{} {
+		conn := ctx.GetConnect()
+		if IsAuthed(conn) {
+			return next(ctx)
+		}
+
+		msgID := ctx.GetMessage().ID()
+		if msgID != loginMsgID {
+			return reject(conn, msgID)
+		}
+
+		if check != nil {
+			if !check(conn, ctx.GetRequest()) {
+				return reject(conn, msgID)
+			}
+			SetAuthed(conn)
+		}
+		return next(ctx)
+	}
+}
+
+//reject 回复错误信息，不会执行之后的中间件和路由
+func reject(conn iface.IConnect, msgID uint32) interface{} {
+	_, _ = conn.Send(msgID, []byte(util.Unauthenticated.Error()))
+	return nil
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
+)
+
+//allowAddress ip黑白名单、同一个ip的连接数限制，不允许时返回false
+func allowAddress(options *Options, connectMgr iface.IConnectManager, address net.Addr) bool {
+
+	// ip黑白名单
+	if filter := options.ipFilter; filter != nil {
+		if tcpAddr, ok := address.(*net.TCPAddr); ok && !filter.Allow(tcpAddr.IP) {
+			options.Logger.Warnf("ip %s not allowed, reject", tcpAddr.IP)
+			return false
+		}
+	}
+
+	// 同一个ip的连接数已达到上限
+	if max := options.MaxConnectionsPerIP; max > 0 {
+		if ip := util.AddrIP(address); ip != "" && connectMgr.CountByIP(ip) >= max {
+			options.Logger.Warnf("connections of ip %s exceed limit %d, reject", ip, max)
+			return false
+		}
+	}
+	return true
+}
+
+//handle 处理一个新连接，设置socket属性后添加到事件循环和连接管理中
+func (a *acceptor) handle(connFd int, sa unix.Sockaddr, loop iface.IEventLoop) {
+
+	address := util.SockaddrToTCPOrUnixAddr(sa)
+
+	// ip黑白名单、同一个ip的连接数，开启PROXY protocol时这里是负载均衡的地址，解析头部之后再检查
+	if !a.options.ProxyProtocol && !allowAddress(a.options, a.connectMgr, address) {
+		_ = unix.Close(connFd)
+		return
+	}
+
+	// 连接数已达到上限，直接拒绝
+	if max := a.options.MaxConnections; max > 0 && a.connectMgr.Len() >= max {
+		if len(a.options.RejectPayload) > 0 {
+			_, _ = unix.Write(connFd, a.options.RejectPayload)
+		}
+		_ = unix.Close(connFd)
+		a.options.Logger.Warnf("connections exceed limit %d, reject %v", max, address)
+		return
+	}
+
+	// 自定义的检查
+	if a.options.OnAccept != nil {
+		if err := a.options.OnAccept(connFd, address); err != nil {
+			_ = unix.Close(connFd)
+			a.options.Logger.Infof("connection from %v rejected by OnAccept: %v", address, err)
+			return
+		}
+	}
+
+	// 设置非阻塞，非tls状态下可以现在设置为非阻塞，如果是tls，则需要在完成tls握手后设置成非阻塞
+	if !a.options.TlsEnable {
+		if err := unix.SetNonblock(connFd, true); err != nil {
+			_ = unix.Close(connFd)
+			return
+		}
+	}
+
+	// 设置是否不延迟，unix domain socket不需要
+	if _, ok := address.(*net.TCPAddr); ok {
+		if err := setNoDelay(connFd, a.options.TCPNoDelay); err != nil {
+			_ = unix.Close(connFd)
+			return
+		}
+	}
+
+	// 内核的收发缓冲区大小
+	if err := setSocketBuffer(connFd, a.options.SocketRecvBuffer, a.options.SocketSendBuffer); err != nil {
+		_ = unix.Close(connFd)
+		a.options.Logger.Warnf("set socket buffer of %v error %v", address, util.WrapSyscallError(util.OpSetsockopt, 0, connFd, err))
+		return
+	}
+
+	baseConnect := newBaseConnect(
+		a.IncrementID(),
+		connFd,
+		address,
+		a.options,
+	)
+	baseConnect.proxyPending = a.options.ProxyProtocol
+	var connect iface.IConnect
+	if a.options.Application == common.RouterMode {
+		connect = newRouterProtocol(baseConnect) // 路由模式，也可以是自定义应用层协议
+	} else {
+		connect = newWebsocketProtocol(baseConnect) // websocket协议
+	}
+
+	// 先分配事件循环、加入管理并执行OnConnect，之后再注册读事件
+	// 避免OnConnect之前就开始处理消息，以及事件循环收到数据时还找不到这个连接
+	loop.Attach(connect)
+	a.connectMgr.Add(connect)
+	atomic.AddUint64(&a.options.counters.accepted, 1)
+
+	// 连接已加入管理
+	if a.options.OnConnect != nil {
+		a.options.OnConnect(connect)
+	}
+
+	// 添加事件循环
+	if err := baseConnect.admit(loop, connect); err != nil {
+		if err != util.ConnectClosed {
+			_ = connect.Close()
+		}
+		return
+	}
+
+	// 限制发送PROXY protocol头部的时间
+	if a.options.ProxyProtocol {
+		baseConnect.watchProxyHeader(connect)
+	}
+}
+
+//setSocketBuffer 设置SO_RCVBUF、SO_SNDBUF，<= 0 表示使用系统默认值
+//内核实际使用的大小会翻倍(linux)，并受net.core.rmem_max、net.core.wmem_max限制
+func setSocketBuffer(fd, recv, send int) error {
+	if recv > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, recv); err != nil {
+			return err
+		}
+	}
+	if send > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//backoff accept出错后等待一段时间，连续出错时从5ms开始翻倍，最长1秒，成功接收连接后重置
+func (a *acceptor) backoff(err error) {
+	if a.retryDelay == 0 {
+		a.retryDelay = 5 * time.Millisecond
+	} else {
+		a.retryDelay *= 2
+	}
+	if a.retryDelay > time.Second {
+		a.retryDelay = time.Second
+	}
+
+	a.options.Logger.Errorf("acceptor error: %v; retrying in %v", err, a.retryDelay)
+	time.Sleep(a.retryDelay)
+}
+
+//start Run时添加所有的listener fd，listenerFd为Server主监听的fd
+func (a *acceptor) start(listenerFd int) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// 已调用Exit，资源已经释放
+	if a.stopped {
+		return util.ServerStopped
+	}
+
+	a.running = true
+	a.listeners = append([]int{listenerFd}, a.listeners...)
+	if atomic.LoadInt32(&a.paused) == 1 {
+		return nil
+	}
+
+	for _, fd := range a.listeners {
+		if err := a.listen(fd, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//exit Exit的公共部分，Run已经执行时调用wake唤醒accept循环，由Run释放资源，否则直接释放
+func (a *acceptor) exit(wake func()) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.stopped {
+		return
+	}
+	a.stopped = true
+
+	if a.closed {
+		return
+	}
+	if !a.running {
+		a.closed = true
+		a.release()
+		return
+	}
+	wake()
+}
+
+//exited accept循环退出时调用，释放资源，已调用Exit时不返回错误（如listener fd已关闭导致的EBADF）
+func (a *acceptor) exited(err error) error {
+	a.Close()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.stopped {
+		return nil
+	}
+	return err
+}
+
+//Close 释放epoll/kqueue，重复调用不会有影响
+func (a *acceptor) Close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.closed {
+		return
+	}
+	a.closed = true
+	a.release()
+}
+
+//AddListener 添加一个监听的fd，新连接和主监听fd上的连接使用同一个事件循环和路由，Run之前或之后都可以调用
+func (a *acceptor) AddListener(fd int) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.listeners = append(a.listeners, fd)
+
+	// 还未开始运行或已暂停，Run、Resume时再添加
+	if !a.running || atomic.LoadInt32(&a.paused) == 1 {
+		return nil
+	}
+	return a.listen(fd, true)
+}
+
+//Pause 暂停接收新连接，已有连接不受影响，新连接会留在内核的accept队列中
+func (a *acceptor) Pause() error {
+	if !atomic.CompareAndSwapInt32(&a.paused, 0, 1) {
+		return nil
+	}
+	return a.listenAll(false)
+}
+
+//Resume 恢复接收新连接
+func (a *acceptor) Resume() error {
+	if !atomic.CompareAndSwapInt32(&a.paused, 1, 0) {
+		return nil
+	}
+	return a.listenAll(true)
+}
+
+//listenAll 添加或移除所有listener fd的可读事件，还未开始运行时Run不会添加
+func (a *acceptor) listenAll(enable bool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.running {
+		return nil
+	}
+
+	for _, fd := range a.listeners {
+		if err := a.listen(fd, enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,17 +3,15 @@
 package server
 
 import (
-	"log"
+	"sync"
 	"syscall"
-
-	"github.com/ikilobyte/netman/common"
-
-	"github.com/ikilobyte/netman/util"
+	"time"
 
 	"golang.org/x/sys/unix"
 
 	"github.com/ikilobyte/netman/eventloop"
 	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
 )
 
 //acceptor 统一处理用来处理新连接
@@ -23,15 +21,29 @@ type acceptor struct {
 	poller     *eventloop.Poller
 	eventfd    int
 	eventbuff  []byte
-	connID     int
 	options    *Options
+	listeners  []int         // 监听的fd，暂停/恢复接收新连接时使用
+	running    bool          // 是否已调用Run
+	stopped    bool          // 是否已调用Exit
+	closed     bool          // 是否已释放epoll/kqueue
+	lock       sync.Mutex    // 保护listeners、running、stopped、closed
+	paused     int32         // 是否已暂停接收新连接
+	retryDelay time.Duration // accept连续出错时的等待时间
 }
 
-func newAcceptor(packer iface.IPacker, connectMgr iface.IConnectManager, options *Options) iface.IAcceptor {
+func newAcceptor(packer iface.IPacker, connectMgr iface.IConnectManager, options *Options) (iface.IAcceptor, error) {
 
-	poller, err := eventloop.NewPoller(connectMgr)
+	poller, err := eventloop.NewPoller(connectMgr, options.Logger)
 	if err != nil {
-		log.Panicln(err)
+		return nil, err
+	}
+
+	// 添加用于退出的事件，Run之前调用Exit时触发的事件也不会丢失
+	if _, err := unix.Kevent(poller.Epfd, []unix.Kevent_t{
+		{Ident: 0, Filter: unix.EVFILT_USER, Flags: unix.EV_ADD | unix.EV_CLEAR},
+	}, nil, nil); err != nil {
+		_ = poller.Close()
+		return nil, err
 	}
 
 	return &acceptor{
@@ -40,23 +52,15 @@ func newAcceptor(packer iface.IPacker, connectMgr iface.IConnectManager, options
 		connectMgr: connectMgr,
 		eventfd:    0,
 		eventbuff:  []byte{},
-		connID:     -1,
 		options:    options,
-	}
+	}, nil
 }
 
 //Run 启动
 func (a *acceptor) Run(listenerFd int, loop iface.IEventLoop) error {
 
-	// 添加event
-	if _, err := unix.Kevent(a.poller.Epfd, []unix.Kevent_t{
-		{Ident: 0, Filter: unix.EVFILT_USER, Flags: unix.EV_ADD | unix.EV_CLEAR},
-	}, nil, nil); err != nil {
-		return err
-	}
-
-	// 添加listener fd
-	if err := a.poller.AddRead(listenerFd, 0); err != nil {
+	// 添加listener fd，已暂停时等恢复后再添加，已调用Exit时返回util.ServerStopped
+	if err := a.start(listenerFd); err != nil {
 		return err
 	}
 
@@ -67,82 +71,74 @@ func (a *acceptor) Run(listenerFd int, loop iface.IEventLoop) error {
 			if err == unix.EAGAIN || err == unix.EINTR {
 				continue
 			}
-			return err
+			return a.exited(err)
 		}
 
 		for i := 0; i < n; i++ {
 			event := a.poller.Events[i]
 			eventFd := int(event.Ident)
 
-			if eventFd == a.eventfd {
-				a.Close()
-				return nil
+			if event.Filter == unix.EVFILT_USER {
+				return a.exited(nil)
 			}
 
+			// darwin没有accept4，持有ForkLock直到设置完close-on-exec，Fork启动的新进程不会继承已有的连接
+			syscall.ForkLock.RLock()
 			connFd, sa, err := unix.Accept(eventFd)
+			if err == nil {
+				unix.CloseOnExec(connFd)
+			}
+			syscall.ForkLock.RUnlock()
 			if err != nil {
-				if err == syscall.Errno(9) {
-					a.Close()
-					return nil
+				// listener已关闭或不可用，无法继续接收新连接
+				if err == unix.EBADF || err == unix.EINVAL {
+					return a.exited(util.WrapSyscallError(util.OpAccept, 0, eventFd, err))
 				}
-				util.Logger.Errorf("acceptor error: %v", err)
-				continue
-			}
-
-			// 设置非阻塞，非tls状态下可以现在设置为非阻塞，如果是tls，则需要在完成tls握手后设置成非阻塞
-			if !a.options.TlsEnable {
-				if err := unix.SetNonblock(connFd, true); err != nil {
-					_ = unix.Close(connFd)
+				// 没有可接收的连接，可能已被其他进程接收（SO_REUSEPORT）
+				if err == unix.EAGAIN {
 					continue
 				}
-			}
 
-			// 设置不延迟
-			if err := unix.SetsockoptInt(connFd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1); err != nil {
-				_ = unix.Close(connFd)
+				// fd耗尽等错误会一直出现，等待一段时间后再重试，避免空转
+				a.backoff(util.WrapSyscallError(util.OpAccept, 0, eventFd, err))
 				continue
 			}
+			a.retryDelay = 0
 
-			baseConnect := newBaseConnect(
-				a.IncrementID(),
-				connFd,
-				util.SockaddrToTCPOrUnixAddr(sa),
-				a.options,
-			)
-			var connect iface.IConnect
-			if a.options.Application == common.RouterMode {
-				connect = newRouterProtocol(baseConnect) // 路由模式，也可以是自定义应用层协议
-			} else {
-				connect = newWebsocketProtocol(baseConnect) // websocket协议
-			}
-
-			// 添加事件循环
-			if err := loop.AddRead(connect); err != nil {
-				_ = connect.Close()
-				continue
-			}
-
-			// 添加到这里
-			a.connectMgr.Add(connect)
+			// 处理新连接
+			a.handle(connFd, sa, loop)
 		}
 	}
 }
 
-func (a *acceptor) IncrementID() int {
-	a.connID += 1
-	return a.connID
+//IncrementID 生成新的连接ID
+func (a *acceptor) IncrementID() uint64 {
+	return a.options.nextConnID()
 }
 
-func (a *acceptor) Close() {
-	_ = a.poller.Remove(a.eventfd)
-	_ = unix.Close(a.eventfd)
+//release 关闭kqueue，EVFILT_USER事件会一起删除，调用方需要持有锁
+func (a *acceptor) release() {
 	_ = a.poller.Close()
 }
 
+//Exit 通知accept循环退出，Run还未执行时直接释放，重复调用不会有影响
 func (a *acceptor) Exit() {
-	_, _ = unix.Kevent(a.poller.Epfd, []unix.Kevent_t{{
-		Ident:  0,
-		Filter: unix.EVFILT_USER,
-		Fflags: unix.NOTE_TRIGGER,
-	}}, nil, nil)
+	a.exit(func() {
+		_, _ = unix.Kevent(a.poller.Epfd, []unix.Kevent_t{{
+			Ident:  0,
+			Filter: unix.EVFILT_USER,
+			Fflags: unix.NOTE_TRIGGER,
+		}}, nil, nil)
+	})
+}
+
+//listen 添加或移除listener fd的可读事件
+func (a *acceptor) listen(fd int, enable bool) error {
+	if enable {
+		return a.poller.AddRead(fd, 0)
+	}
+	_, err := unix.Kevent(a.poller.Epfd, []unix.Kevent_t{
+		{Ident: uint64(fd), Filter: unix.EVFILT_READ, Flags: unix.EV_DELETE},
+	}, nil, nil)
+	return err
 }
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//asyncPacket 等待异步发送的消息
+type asyncPacket struct {
+	msgID uint32
+	data  []byte
+}
+
+//asyncSend 放入连接的发送队列后立即返回，通过Submit唤醒事件循环按顺序调用connect.Send，data放入队列后不能再修改
+//TLS的写入是阻塞的、UDP没有事件循环，这两种情况使用单独的发送协程
+func (c *BaseConnect) asyncSend(connect iface.IConnect, msgID uint32, data []byte) error {
+
+	if c.ctx.Err() != nil {
+		return util.ConnectClosed
+	}
+
+	if c.isDraining() {
+		return util.ConnectClosing
+	}
+
+	// 第一次使用时才创建队列和发送协程
+	c.sendOnce.Do(func() {
+		size := c.options.SendQueueSize
+		if size <= 0 {
+			size = DefaultSendQueueSize
+		}
+		c.sendQueue = make(chan asyncPacket, size)
+		c.sendByLoop = !c.GetTLSEnable() && c.poller != nil
+		if !c.sendByLoop {
+			go c.sendLoop(connect)
+		}
+	})
+
+	select {
+	case c.sendQueue <- asyncPacket{msgID: msgID, data: data}:
+		atomic.AddInt64(&c.asyncPending, 1)
+		atomic.AddInt64(&c.asyncBytes, int64(len(data)))
+		if c.sendByLoop {
+			c.scheduleFlush(connect)
+		}
+		return nil
+	default:
+	}
+
+	// 队列已满
+	switch c.options.SendQueuePolicy {
+	case common.SendQueueDrop:
+		return nil
+	case common.SendQueueClose:
+		c.options.Logger.Infof("connID[%d] send queue is full, close", c.id)
+		_ = closeWith(connect, common.CloseRejected)
+	}
+	return util.SendQueueFull
+}
+
+//sendLoop 发送协程，连接关闭后退出，队列中未发送的消息会被丢弃
+func (c *BaseConnect) sendLoop(connect iface.IConnect) {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case packet := <-c.sendQueue:
+			c.sendPacket(connect, packet)
+		}
+	}
+}
+
+//scheduleFlush 提交到事件循环发送队列中的消息，已经提交过、还未执行时不重复提交
+//事件循环已停止时不再发送，连接会随事件循环一起关闭
+func (c *BaseConnect) scheduleFlush(connect iface.IConnect) {
+	if !atomic.CompareAndSwapInt32(&c.flushScheduled, 0, 1) {
+		return
+	}
+	if err := c.poller.Submit(func() { c.flushQueue(connect) }); err != nil {
+		atomic.StoreInt32(&c.flushScheduled, 0)
+	}
+}
+
+//flushQueue 在事件循环的协程中发送队列中的消息，非TLS的写入不会阻塞，发送不完的数据等待可写后由ProceedWrite继续发送
+//每次最多发送队列长度个，还有剩余时重新提交，避免一直占用事件循环
+func (c *BaseConnect) flushQueue(connect iface.IConnect) {
+
+	// 先重置，之后放入队列的消息会重新提交
+	atomic.StoreInt32(&c.flushScheduled, 0)
+
+	for i := cap(c.sendQueue); i > 0; i-- {
+		if c.ctx.Err() != nil {
+			return
+		}
+		select {
+		case packet := <-c.sendQueue:
+			c.sendPacket(connect, packet)
+		default:
+			return
+		}
+	}
+	c.scheduleFlush(connect)
+}
+
+//sendPacket 发送一个异步队列中的消息，CloseGracefully期间也需要发送出去
+func (c *BaseConnect) sendPacket(connect iface.IConnect, packet asyncPacket) {
+
+	// 取出后就不再计入队列，未写入内核的部分由写入队列计算，WriteBacklog不会重复统计
+	atomic.AddInt64(&c.asyncBytes, -int64(len(packet.data)))
+
+	var err error
+	if sender, ok := connect.(queuedSender); ok {
+		_, err = sender.send(packet.msgID, packet.data)
+	} else {
+		_, err = connect.Send(packet.msgID, packet.data)
+	}
+	atomic.AddInt64(&c.asyncPending, -1)
+	if err != nil {
+		c.options.Logger.Infof("connID[%d] async send msgID[%d] error %v", c.id, packet.msgID, err)
+	}
+}
+
+//AsyncSend 异步发送，仅路由模式可用
+func (c *BaseConnect) AsyncSend(msgID uint32, bs []byte) error {
+	return util.ApplicationNotRouterMode
+}
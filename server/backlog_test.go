@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+func TestWriteBacklogAsyncQueue(t *testing.T) {
+	const (
+		count = 10
+		size  = 1024
+	)
+
+	connected := make(chan iface.IConnect, 1)
+	s := startServer(t, WithOnConnect(func(connect iface.IConnect) {
+		connected <- connect
+	}))
+	conn := dial(t, s)
+
+	var connect iface.IConnect
+	select {
+	case connect = <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called")
+	}
+
+	// 在事件循环中放入队列，这个任务结束之前队列中的消息不会被发送
+	backlog := make(chan int, 1)
+	err := connect.GetPoller().Submit(func() {
+		for i := 0; i < count; i++ {
+			if err := connect.AsyncSend(1, make([]byte, size)); err != nil {
+				t.Error(err)
+			}
+		}
+		backlog <- connect.WriteBacklog()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := <-backlog; n != count*size {
+		t.Fatalf("WriteBacklog is %d with %d bytes queued by AsyncSend", n, count*size)
+	}
+
+	// 发送完毕后不再积压
+	for i := 0; i < count; i++ {
+		if _, err := readFrame(conn, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, time.Second, func() bool {
+		return connect.WriteBacklog() == 0
+	})
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ikilobyte/netman/common"
@@ -16,26 +19,67 @@ import (
 )
 
 type BaseConnect struct {
-	id                 int                 // 自定义生成的ID
-	fd                 int                 // 系统分配的fd
-	epfd               int                 // 管理这个连接的epoll
-	packer             iface.IPacker       // 封包解包实现，可以自行实现
-	Address            net.Addr            //
-	hooks              iface.IHooks        //
-	writeBuff          []byte              // 待发送的数据缓冲，如果这个变为空，那就表示这一次的全部发送完毕了！
-	poller             iface.IPoller       //
-	writeQ             *util.Queue         //
-	state              common.ConnectState // 当前状态，0 离线，1 在线，2 epoll状态是可写，3 epoll状态是可读
-	lastMessageTime    time.Time           // 最后一次发送消息的时间，用于心跳检测
-	tlsEnable          bool                // 是否开启了tls
-	handshakeCompleted bool                // tls握手是否完成
-	options            *Options            // 可选项配置
-	tlsLayer           *tls.Conn           // TLS层
-	tlsRawSize         int                 // tls原始字节数据，对应*tls.Conn.rawInput中是否还有数据未读
-	tlsWritePacketSize int                 // 发送数据包的长度
-}
-
-func newBaseConnect(id int, fd int, address net.Addr, options *Options) *BaseConnect {
+	id                 uint64                 // 自定义生成的ID，进程内唯一
+	fd                 int                    // 系统分配的fd
+	epfd               int                    // 管理这个连接的epoll
+	packer             iface.IPacker          // 封包解包实现，可以自行实现
+	packerValue        atomic.Value           // SetPacker设置的封包解包实现，保存的是packerBox
+	Address            net.Addr               // 对端地址
+	localAddress       net.Addr               // 本端地址
+	hooks              iface.IHooks           //
+	writeBuff          []byte                 // 待发送的数据缓冲，如果这个变为空，那就表示这一次的全部发送完毕了！
+	poller             iface.IPoller          //
+	writeQ             *util.Queue            //
+	state              common.ConnectState    // 当前状态，0 离线，1 在线，2 epoll状态是可写，3 epoll状态是可读
+	lastMessageTime    int64                  // 最后一次收到数据的时间(UnixNano)，用于心跳检测和空闲超时
+	tlsEnable          bool                   // 是否开启了tls
+	handshakeCompleted bool                   // tls握手是否完成
+	options            *Options               // 可选项配置
+	tlsLayer           *tls.Conn              // TLS层
+	tlsRawSize         int                    // tls原始字节数据，对应*tls.Conn.rawInput中是否还有数据未读
+	tlsWritePacketSize int                    // 发送数据包的长度
+	closeOnce          sync.Once              // 保证关闭回调只会执行一次
+	properties         map[string]interface{} // 连接上保存的自定义属性
+	propertyLock       sync.RWMutex           //
+	writeDeadline      int64                  // 写入截止时间(UnixNano)，0表示使用Options.WriteTimeout
+	pendingSince       time.Time              // 进入EPOLLOUT状态的时间，写入队列中的数据从这个时间开始等待发送
+	pingTime           int64                  // 最后一次发送心跳ping的时间(UnixNano)
+	pongTime           int64                  // 最后一次收到心跳pong的时间(UnixNano)
+	ctx                context.Context        // 连接的生命周期，关闭时取消
+	cancel             context.CancelFunc     //
+	unread             []byte                 // 探测协议时已读取，但还未被解析的数据
+	sendQueue          chan asyncPacket       // 异步发送队列
+	sendOnce           sync.Once              // 第一次异步发送时创建队列
+	sendByLoop         bool                   // 异步发送队列由事件循环发送，TLS、UDP时为false，使用发送协程
+	flushScheduled     int32                  // 已通过Submit提交了发送任务，还未执行
+	closed             int32                  // 是否已关闭，保证关闭流程只执行一次
+	writeLock          sync.Mutex             // 路由、事件循环可能同时写入，保证数据包的顺序和完整
+	readLimiter        *util.TokenBucket      // 读取限速，未配置Options.ReadRateLimit时为nil
+	readPaused         int32                  // 超过读取速率后暂停读取，1表示已暂停
+	connectedAt        time.Time              // 建立连接的时间
+	writeClosed        int32                  // 是否已关闭写端
+	tags               map[string]string      // 连接的标签，未设置时为nil
+	sendSeq            uint64                 // 发送的序列号
+	seqWindow          *util.SeqWindow        // 收到的序列号去重，未开启时为nil
+	draining           int32                  // 是否正在CloseGracefully，1表示不再接收新的发送
+	asyncPending       int64                  // 异步发送队列中还未发送完毕的数量
+	asyncBytes         int64                  // 异步发送队列中还未发送的包体字节数
+	closeErr           error                  // 导致连接关闭的错误，对端正常关闭时为nil
+	closeReason        int32                  // 连接关闭的原因，common.CloseReason
+	proxyPending       bool                   // 还未解析PROXY protocol头部
+	proxyBuf           []byte                 // 已读取的不完整的PROXY protocol头部
+	proxyRest          []byte                 // 解析PROXY protocol头部时多读取的数据
+	userPaused         int32                  // 调用了PauseRead，1表示已暂停，直到调用ResumeRead
+	pendingBytes       int64                  // 写入队列中还未写入内核的字节数
+	bytesRead          uint64                 // 这个连接累计读取的字节数
+	bytesWritten       uint64                 // 这个连接累计写入内核的字节数
+	tlsConfig          *tls.Config            // 建立连接时的tls配置，ReloadTLS不影响已有的连接
+	tasks              []func()               // Go提交的任务，按顺序执行
+	taskLock           sync.Mutex             // 保护tasks、taskRunning
+	taskRunning        bool                   // 是否已有协程在执行任务
+}
+
+func newBaseConnect(id uint64, fd int, address net.Addr, options *Options) *BaseConnect {
 	connect := &BaseConnect{
 		id:                 id,
 		fd:                 fd,
@@ -44,21 +88,40 @@ func newBaseConnect(id int, fd int, address net.Addr, options *Options) *BaseCon
 		hooks:              options.Hooks,
 		writeQ:             util.NewQueue(), // 待发送的数据队列
 		state:              common.OnLine,   // 状态
-		lastMessageTime:    time.Now(),      // 初始化
 		tlsEnable:          options.TlsEnable,
 		handshakeCompleted: false,
 		options:            options,
 		tlsLayer:           nil,
 		tlsRawSize:         0,
+		properties:         make(map[string]interface{}),
 	}
 
-	// TLS相关配置
+	// 初始化
+	connect.ctx, connect.cancel = context.WithCancel(options.ctx)
+	connect.connectedAt = time.Now()
+	connect.SetLastMessageTime(connect.connectedAt)
+	connect.sentPing(time.Now())
+	connect.receivedPong(time.Now())
+
+	// 序列号去重
+	if options.Sequence && options.SeqDedupWindow > 0 {
+		connect.seqWindow = util.NewSeqWindow(options.SeqDedupWindow)
+	}
+
+	// 读取限速
+	if options.ReadRateLimit > 0 {
+		connect.readLimiter = util.NewTokenBucket(options.ReadRateLimit, options.ReadRateBurst)
+	}
+
+	// 本端地址
+	if sa, err := unix.Getsockname(fd); err == nil {
+		connect.localAddress = util.SockaddrToTCPOrUnixAddr(sa)
+	}
+
+	// TLS相关配置，使用创建连接时的配置，之后ReloadTLS不影响这个连接
 	if connect.options.TlsEnable {
-		if connect.options.TlsConfig != nil {
-			connect.tlsLayer = tls.Server(connect, connect.options.TlsConfig)
-		} else {
-			connect.tlsLayer = tls.Server(connect, &tls.Config{Certificates: []tls.Certificate{*connect.options.TlsCertificate}})
-		}
+		connect.tlsConfig = connect.options.loadTLSConfig()
+		connect.tlsLayer = tls.Server(connect, connect.tlsConfig)
 	}
 
 	// 执行onopen事件
@@ -69,8 +132,24 @@ func newBaseConnect(id int, fd int, address net.Addr, options *Options) *BaseCon
 	return connect
 }
 
+//connIDCounter 所有Server、Client、UDPServer共用，进程内生成的连接ID不会重复
+var connIDCounter uint64
+
+//nextConnID 生成新的连接ID，从1开始单调递增，uint64在进程的生命周期内不会溢出
+func nextConnID() uint64 {
+	return atomic.AddUint64(&connIDCounter, 1)
+}
+
+//nextConnID 配置了IDGenerator时使用自定义的方式生成连接ID
+func (o *Options) nextConnID() uint64 {
+	if o.IDGenerator != nil {
+		return o.IDGenerator()
+	}
+	return nextConnID()
+}
+
 //GetID 获取连接ID
-func (c *BaseConnect) GetID() int {
+func (c *BaseConnect) GetID() uint64 {
 	return c.id
 }
 
@@ -82,8 +161,37 @@ func (c *BaseConnect) GetFd() int {
 // Read 读取数据
 func (c *BaseConnect) Read(bs []byte) (int, error) {
 
+	// 已暂停读取，边缘触发时会一直读取，需要在这里停止
+	if atomic.LoadInt32(&c.readPaused) == 1 || atomic.LoadInt32(&c.userPaused) == 1 {
+		return 0, unix.EAGAIN
+	}
+
+	// 开启了PROXY protocol，先解析头部
+	if c.proxyPending {
+		if err := c.readProxyHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	// 解析头部时多读取的数据
+	if len(c.proxyRest) > 0 {
+		n := copy(bs, c.proxyRest)
+		c.proxyRest = c.proxyRest[n:]
+		if c.handshakeCompleted {
+			c.tlsRawSize += n
+		}
+		return n, nil
+	}
+
 	n, err := unix.Read(c.fd, bs)
 
+	// 任何读取到的数据都表示连接是活跃的
+	if n > 0 {
+		c.SetLastMessageTime(time.Now())
+		c.addRead(n)
+		c.throttleRead(n)
+	}
+
 	// 已完成了TLS握手
 	if c.handshakeCompleted {
 		if n >= 0 {
@@ -111,28 +219,61 @@ func (c *BaseConnect) Read(bs []byte) (int, error) {
 //Write ..只是为了实现tls，请勿调用此方法，应该调用Send方法
 func (c *BaseConnect) Write(dataPack []byte) (int, error) {
 
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	// 已关闭写端
+	if atomic.LoadInt32(&c.writeClosed) == 1 {
+		return 0, util.ConnectWriteClosed
+	}
+
 	// 当前连接是否为 EPOLLOUT 事件
 	totalBytes := len(dataPack)
+	timeout, limited := c.writeTimeout()
 	if c.state == common.EPollOUT {
+
+		// 队列中的数据超时仍未发送完毕，对端可能已经无法接收数据
+		if limited && time.Since(c.pendingSince) >= timeout {
+			return 0, util.WriteTimeout
+		}
 		c.writeQ.Push(dataPack)
+		atomic.AddInt64(&c.pendingBytes, int64(totalBytes))
 		return totalBytes, nil
 	}
 
 	// 当前是TLS模式，且是非阻塞模式
 	if c.GetHandshakeCompleted() {
+
+		// 已经超过了截止时间
+		if limited && timeout <= 0 {
+			return 0, util.WriteTimeout
+		}
+
 		if err := unix.SetNonblock(c.fd, false); err != nil {
+			c.SetCloseReason(common.CloseWriteError)
 			_ = c.Close()
-			return -1, err
+			return -1, util.WrapSyscallError(util.OpSetsockopt, c.id, c.fd, err)
 		}
+
+		// 阻塞模式下通过SO_SNDTIMEO限制写入时间，0表示不限制
+		tv := unix.NsecToTimeval(int64(timeout))
+		_ = unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv)
 	}
 
 	n, err := unix.Write(c.fd, dataPack)
+	c.addWritten(n)
 
 	if err != nil {
 		// FD 已断开
 		if err == unix.EBADF || err == unix.EPIPE {
 			//_ = c.Close()
-			return -1, err
+			return -1, util.WrapSyscallError(util.OpWrite, c.id, c.fd, err)
+		}
+
+		// 阻塞模式下写入超时
+		if c.GetHandshakeCompleted() && limited && err == unix.EAGAIN {
+			_ = unix.SetNonblock(c.fd, true)
+			return 0, util.WriteTimeout
 		}
 	}
 
@@ -150,7 +291,8 @@ func (c *BaseConnect) Write(dataPack []byte) (int, error) {
 		// 把剩下的保存到写入队列中
 		c.SetState(common.EPollOUT)
 		c.writeQ.Push(dataPack[n:])
-		_ = c.poller.ModWrite(c.fd, c.id)
+		atomic.AddInt64(&c.pendingBytes, int64(totalBytes-n))
+		_ = c.poller.ModWrite(c.fd, int(c.id))
 		return totalBytes, nil
 	}
 
@@ -158,11 +300,12 @@ func (c *BaseConnect) Write(dataPack []byte) (int, error) {
 	if n < 0 {
 		c.SetState(common.EPollOUT)
 		c.writeQ.Push(dataPack)
-		_ = c.poller.ModWrite(c.fd, c.id)
+		atomic.AddInt64(&c.pendingBytes, int64(totalBytes))
+		_ = c.poller.ModWrite(c.fd, int(c.id))
 
 		return totalBytes, nil
 	}
-	return n, err
+	return n, util.WrapSyscallError(util.OpWrite, c.id, c.fd, err)
 }
 
 //Text ..
@@ -175,11 +318,34 @@ func (c *BaseConnect) Binary(bytes []byte) (int, error) {
 	return 0, nil
 }
 
-//GetPacker 获取packer
+//packerBox atomic.Value每次保存的类型必须一致，不同的IPacker实现需要包装一层
+type packerBox struct {
+	packer iface.IPacker
+}
+
+//GetPacker 获取packer，调用过SetPacker时返回连接单独的packer
 func (c *BaseConnect) GetPacker() iface.IPacker {
+	if packer, ok := c.customPacker(); ok {
+		return packer
+	}
 	return c.packer
 }
 
+//SetPacker 之后读取和发送的数据包使用这个连接单独的封包解包实现，packer为nil时恢复使用默认的
+//正在读取中的包仍然使用之前的packer，一般在处理握手消息的路由中调用，对端收到响应后再切换
+func (c *BaseConnect) SetPacker(packer iface.IPacker) {
+	c.packerValue.Store(packerBox{packer: packer})
+}
+
+//customPacker 调用SetPacker设置的packer
+func (c *BaseConnect) customPacker() (iface.IPacker, bool) {
+	box, ok := c.packerValue.Load().(packerBox)
+	if !ok || box.packer == nil {
+		return nil, false
+	}
+	return box.packer, true
+}
+
 func (c *BaseConnect) GetAddress() net.Addr {
 	return c.Address
 }
@@ -199,6 +365,45 @@ func (c *BaseConnect) SetPoller(poller iface.IPoller) {
 	c.poller = poller
 }
 
+//SetCloseError 记录导致连接关闭的错误，只保留第一个
+func (c *BaseConnect) SetCloseError(err error) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	if c.closeErr == nil {
+		c.closeErr = err
+	}
+}
+
+//CloseError 导致连接关闭的错误，如：ECONNRESET、EPIPE，对端正常关闭或主动关闭时为nil
+func (c *BaseConnect) CloseError() error {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+	return c.closeErr
+}
+
+//SetCloseReason 记录连接关闭的原因，需要在Close之前调用，只保留第一个
+func (c *BaseConnect) SetCloseReason(reason common.CloseReason) {
+	atomic.CompareAndSwapInt32(&c.closeReason, int32(common.CloseNone), int32(reason))
+}
+
+//CloseReason 连接关闭的原因，OnClose中可用，未关闭时为common.CloseNone
+func (c *BaseConnect) CloseReason() common.CloseReason {
+	return common.CloseReason(atomic.LoadInt32(&c.closeReason))
+}
+
+//closeReasonSetter .
+type closeReasonSetter interface {
+	SetCloseReason(reason common.CloseReason)
+}
+
+//closeWith 记录关闭原因后关闭连接
+func closeWith(connect iface.IConnect, reason common.CloseReason) error {
+	if setter, ok := connect.(closeReasonSetter); ok {
+		setter.SetCloseReason(reason)
+	}
+	return connect.Close()
+}
+
 //SetWriteBuff .
 func (c *BaseConnect) SetWriteBuff(bytes []byte) {
 	c.writeBuff = bytes
@@ -230,12 +435,15 @@ func (c *BaseConnect) GetWriteBuff() ([]byte, bool) {
 
 //SetState state取值范围 0 离线，1 在线，2 epoll状态是可写，3 epoll状态是可读
 func (c *BaseConnect) SetState(state common.ConnectState) {
+	if state == common.EPollOUT && c.state != common.EPollOUT {
+		c.pendingSince = time.Now()
+	}
 	c.state = state
 }
 
 //SetLastMessageTime .
 func (c *BaseConnect) SetLastMessageTime(duration time.Time) {
-	c.lastMessageTime = duration
+	atomic.StoreInt64(&c.lastMessageTime, duration.UnixNano())
 }
 
 func (c *BaseConnect) GetTLSEnable() bool {
@@ -250,9 +458,12 @@ func (c *BaseConnect) SetHandshakeCompleted() {
 	c.handshakeCompleted = true
 }
 
-//GetCertificate 获取tls证书配置
+//GetCertificate 获取这个连接使用的tls证书，有多个证书时返回第一个，使用GetCertificate回调时返回空的证书
 func (c *BaseConnect) GetCertificate() tls.Certificate {
-	return *c.options.TlsCertificate
+	if c.tlsConfig != nil && len(c.tlsConfig.Certificates) > 0 {
+		return c.tlsConfig.Certificates[0]
+	}
+	return tls.Certificate{}
 }
 
 //GetTLSLayer 获取TLS层的对象
@@ -268,35 +479,62 @@ func (c *BaseConnect) GetConnectMgr() iface.IConnectManager {
 //ProceedWrite 继续将未发送完毕的数据发送出去
 func (c *BaseConnect) ProceedWrite() error {
 
-	// 1. 获取一个待发送的数据
-	dataBuff, empty := c.GetWriteBuff()
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
 
-	// 2. 队列中没有未发送完毕的数据，将当前连接改为可读事件
-	if empty {
+	for {
+		// 1. 获取一个待发送的数据
+		dataBuff, empty := c.GetWriteBuff()
 
-		// 更改为可读状态
-		if err := c.GetPoller().ModRead(c.fd, c.id); err != nil {
-			return err
-		}
+		// 2. 队列中没有未发送完毕的数据，将当前连接改为可读事件
+		if empty {
 
-		// 同步状态
-		c.SetState(common.EPollIN)
+			// 更改为可读状态
+			if err := c.GetPoller().ModRead(c.fd, int(c.id)); err != nil {
+				return err
+			}
 
-		return nil
-	}
+			// 同步状态
+			c.SetState(common.EPollIN)
 
-	// 3. 发送
-	n, err := unix.Write(c.GetFd(), dataBuff)
+			// 调用了PauseRead、超过读取速率，写入完毕后仍然保持暂停
+			if atomic.LoadInt32(&c.userPaused) == 1 || atomic.LoadInt32(&c.readPaused) == 1 {
+				if err := c.GetPoller().PauseRead(c.fd, int(c.id)); err != nil {
+					return err
+				}
+			}
 
-	// fmt.Printf("dataBuff %d empty %v 已发送[%d] 剩余[%d]\n", len(dataBuff), empty, n, len(dataBuff)-n)
-	if err != nil {
-		return err
-	}
+			// 调用CloseWrite时还有数据未发送，发送完毕后再关闭写端
+			if atomic.LoadInt32(&c.writeClosed) == 1 {
+				return unix.Shutdown(c.fd, unix.SHUT_WR)
+			}
 
-	// 设置 writeBuff
-	c.SetWriteBuff(dataBuff[n:])
+			return nil
+		}
 
-	return nil
+		// 3. 发送
+		n, err := unix.Write(c.GetFd(), dataBuff)
+		c.addWritten(n)
+
+		// 边缘触发时缓冲区已写满，等待下一次可写通知
+		if err == unix.EAGAIN && c.options.EpollEdgeTriggered {
+			return nil
+		}
+
+		// fmt.Printf("dataBuff %d empty %v 已发送[%d] 剩余[%d]\n", len(dataBuff), empty, n, len(dataBuff)-n)
+		if err != nil {
+			return err
+		}
+
+		// 设置 writeBuff
+		c.SetWriteBuff(dataBuff[n:])
+		atomic.AddInt64(&c.pendingBytes, -int64(n))
+
+		// 水平触发时未发送完的数据会再次通知，边缘触发时需要一直写入，直到队列为空或缓冲区写满
+		if !c.options.EpollEdgeTriggered {
+			return nil
+		}
+	}
 }
 
 //Close 会被重写，不会执行到这里
@@ -309,11 +547,36 @@ func (c *BaseConnect) Send(msgID uint32, bs []byte) (int, error) {
 	return 0, nil
 }
 
+//SendRaw 不经过封包，直接发送已经是协议格式的数据，如：代理、回放抓包的数据、子协议的握手数据，调用方需要保证数据格式的正确
+//开启TLS时仍然会经过TLS加密，升级为websocket的连接不会封装为帧，SendNoFlush缓存的数据不会先发送
+func (c *BaseConnect) SendRaw(data []byte) error {
+	if c.isDraining() {
+		return util.ConnectClosing
+	}
+
+	if c.GetTLSEnable() {
+		_, err := c.tlsLayer.Write(data)
+		return err
+	}
+	_, err := c.Write(data)
+	return err
+}
+
 // 以下方法是为了实现TLS，实际并未实现
 
 //GetLastMessageTime .
 func (c *BaseConnect) GetLastMessageTime() time.Time {
-	return c.lastMessageTime
+	return time.Unix(0, atomic.LoadInt64(&c.lastMessageTime))
+}
+
+//ConnectedAt 建立连接的时间
+func (c *BaseConnect) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+//LastActiveAt 最后一次收到数据的时间
+func (c *BaseConnect) LastActiveAt() time.Time {
+	return c.GetLastMessageTime()
 }
 
 //GetPoller ..
@@ -321,12 +584,12 @@ func (c *BaseConnect) GetPoller() iface.IPoller {
 	return c.poller
 }
 
-//LocalAddr ..
+//LocalAddr 本端地址
 func (c *BaseConnect) LocalAddr() net.Addr {
-	return nil
+	return c.localAddress
 }
 
-//RemoteAddr ..
+//RemoteAddr 对端地址，和GetAddress一致
 func (c *BaseConnect) RemoteAddr() net.Addr {
 	return c.Address
 }
@@ -341,13 +604,116 @@ func (c *BaseConnect) SetReadDeadline(t time.Time) error {
 	return nil
 }
 
-//SetWriteDeadline ..
+//SetWriteDeadline 设置写入截止时间，超过这个时间仍未发送完毕的写入会返回util.WriteTimeout，零值表示使用Options.WriteTimeout
 func (c *BaseConnect) SetWriteDeadline(t time.Time) error {
+	if t.IsZero() {
+		atomic.StoreInt64(&c.writeDeadline, 0)
+		return nil
+	}
+	atomic.StoreInt64(&c.writeDeadline, t.UnixNano())
 	return nil
 }
 
+//writeTimeout 本次写入允许的时间，第二个返回值表示是否有限制
+func (c *BaseConnect) writeTimeout() (time.Duration, bool) {
+	if deadline := atomic.LoadInt64(&c.writeDeadline); deadline > 0 {
+		return time.Until(time.Unix(0, deadline)), true
+	}
+
+	if c.options.WriteTimeout > 0 {
+		return c.options.WriteTimeout, true
+	}
+	return 0, false
+}
+
+//markClosed 标记为已关闭，只有第一次调用返回true
+func (c *BaseConnect) markClosed() bool {
+	return atomic.CompareAndSwapInt32(&c.closed, 0, 1)
+}
+
+//isClosed 是否已经标记为关闭
+func (c *BaseConnect) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+//detach 移除事件监听并从连接管理中移除，添加到事件循环之前关闭时poller为空
+func (c *BaseConnect) detach(connect iface.IConnect) {
+	if c.poller == nil {
+		return
+	}
+	_ = c.poller.Remove(c.fd)
+	c.poller.GetConnectMgr().Remove(connect)
+}
+
+//runCloseHooks 执行关闭回调，无论从哪个路径关闭，同一个连接只会执行一次
+func (c *BaseConnect) runCloseHooks(connect iface.IConnect) {
+	c.closeOnce.Do(func() {
+		atomic.AddUint64(&c.options.counters.closed, 1)
+		c.cancel()
+
+		// 未记录原因时为主动关闭，在回调之前设置
+		c.SetCloseReason(common.CloseActive)
+
+		if c.hooks != nil {
+			c.hooks.OnClose(connect)
+		}
+
+		if c.options.OnClose != nil {
+			c.options.OnClose(connect)
+		}
+
+		// 回调中可能还会用到，执行完回调后再清除
+		c.propertyLock.Lock()
+		c.properties = make(map[string]interface{})
+		c.tags = nil
+		c.propertyLock.Unlock()
+	})
+}
+
+//SetProperty 设置连接属性，如登录后的用户ID
+func (c *BaseConnect) SetProperty(key string, value interface{}) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	c.properties[key] = value
+}
+
+//GetProperty 获取连接属性
+func (c *BaseConnect) GetProperty(key string) (interface{}, error) {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+	if value, ok := c.properties[key]; ok {
+		return value, nil
+	}
+	return nil, util.PropertyNotFound
+}
+
+//RemoveProperty 删除连接属性
+func (c *BaseConnect) RemoveProperty(key string) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	delete(c.properties, key)
+}
+
 //readData 读取数据
 func (c *BaseConnect) readData(bs []byte) (int, error) {
+
+	// 优先返回探测协议时读取的数据，不足时继续从连接中读取
+	if len(c.unread) > 0 {
+		n := copy(bs, c.unread)
+		c.unread = c.unread[n:]
+		if n < len(bs) {
+			if m, _ := c.rawRead(bs[n:]); m > 0 {
+				n += m
+			}
+		}
+		return n, nil
+	}
+
+	return c.rawRead(bs)
+}
+
+//rawRead 从连接中读取数据
+func (c *BaseConnect) rawRead(bs []byte) (int, error) {
 	if c.GetTLSEnable() {
 		return c.GetTLSLayer().Read(bs)
 	}
@@ -357,3 +723,211 @@ func (c *BaseConnect) readData(bs []byte) (int, error) {
 func (c *BaseConnect) GetQueryStringParam() url.Values {
 	return make(url.Values)
 }
+
+//Context 连接的context，连接关闭或服务关闭时会被取消
+func (c *BaseConnect) Context() context.Context {
+	return c.ctx
+}
+
+//throttleRead 超过读取速率后暂停读事件，等待令牌足够后再恢复，数据会保留在内核缓冲区中，不会丢弃
+func (c *BaseConnect) throttleRead(n int) {
+	if c.readLimiter == nil || c.poller == nil {
+		return
+	}
+
+	wait := c.readLimiter.Take(n)
+	if wait <= 0 {
+		return
+	}
+
+	// state由Write、ProceedWrite在持有writeLock时修改，和PauseRead、ResumeRead一样需要加锁
+	// 正在等待可写时不能改为PauseRead，否则可写事件会丢失，写入完毕后由ProceedWrite暂停
+	c.writeLock.Lock()
+	if c.state != common.EPollOUT {
+		if err := c.poller.PauseRead(c.fd, int(c.id)); err != nil {
+			c.writeLock.Unlock()
+			return
+		}
+	}
+	atomic.StoreInt32(&c.readPaused, 1)
+	c.writeLock.Unlock()
+
+	time.AfterFunc(wait, func() {
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+
+		atomic.StoreInt32(&c.readPaused, 0)
+
+		// 已关闭的fd可能被新连接复用
+		if atomic.LoadInt32(&c.closed) == 1 {
+			return
+		}
+
+		// 写入完毕后会恢复为可读，调用了PauseRead时由ResumeRead恢复
+		if c.state == common.EPollOUT || atomic.LoadInt32(&c.userPaused) == 1 {
+			return
+		}
+		_ = c.poller.ResumeRead(c.fd, int(c.id))
+	})
+}
+
+//PauseRead 暂停读取这个连接的数据，用于处理不过来时的流量控制，数据会保留在内核缓冲区中，缓冲区满后对端无法继续发送
+//暂停期间仍然可以发送数据，对端关闭连接、连接出错时仍然会关闭连接并回调OnClose(仅linux，macOS在ResumeRead之后才能感知)
+func (c *BaseConnect) PauseRead() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return util.ConnectClosed
+	}
+	if !atomic.CompareAndSwapInt32(&c.userPaused, 0, 1) {
+		return nil
+	}
+
+	// 正在等待可写，写入完毕后再暂停
+	if c.state == common.EPollOUT {
+		return nil
+	}
+	return c.poller.PauseRead(c.fd, int(c.id))
+}
+
+//ResumeRead 恢复读取，暂停期间内核缓冲区中的数据会继续被读取
+func (c *BaseConnect) ResumeRead() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return util.ConnectClosed
+	}
+	if !atomic.CompareAndSwapInt32(&c.userPaused, 1, 0) {
+		return nil
+	}
+
+	// 超过读取速率的暂停由定时器恢复，等待可写时由ProceedWrite恢复
+	if atomic.LoadInt32(&c.readPaused) == 1 || c.state == common.EPollOUT {
+		return nil
+	}
+	return c.poller.ResumeRead(c.fd, int(c.id))
+}
+
+//PendingBytes 内核缓冲区已满时，写入队列中等待可写事件(EPOLLOUT)后再发送的字节数，可用于监控发送不过来的慢连接
+func (c *BaseConnect) PendingBytes() int {
+	return int(atomic.LoadInt64(&c.pendingBytes))
+}
+
+//WriteBacklog 已发送但还未写入内核的字节数，包括写入队列中等待可写的字节数，以及AsyncSend队列中还未发送的包体字节数
+func (c *BaseConnect) WriteBacklog() int {
+	return c.PendingBytes() + int(atomic.LoadInt64(&c.asyncBytes))
+}
+
+//BytesRead 这个连接累计读取的字节数，包括包头、TLS记录等
+func (c *BaseConnect) BytesRead() uint64 {
+	return atomic.LoadUint64(&c.bytesRead)
+}
+
+//BytesWritten 这个连接累计写入内核的字节数
+func (c *BaseConnect) BytesWritten() uint64 {
+	return atomic.LoadUint64(&c.bytesWritten)
+}
+
+//addRead 记录读取的字节数，同时累加到全局的计数器
+func (c *BaseConnect) addRead(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.bytesRead, uint64(n))
+	}
+	c.options.counters.addRead(n)
+}
+
+//addWritten 记录写入的字节数，同时累加到全局的计数器
+func (c *BaseConnect) addWritten(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.bytesWritten, uint64(n))
+	}
+	c.options.counters.addWritten(n)
+}
+
+//admit 注册到事件循环，在OnConnect之后调用，OnConnect中已关闭的连接不再注册
+//OnConnect中发送的数据未写完、调用了PauseRead时，fd还没有注册，这里补上对应的事件
+func (c *BaseConnect) admit(loop iface.IEventLoop, connect iface.IConnect) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return util.ConnectClosed
+	}
+	if err := loop.AddRead(connect); err != nil {
+		return err
+	}
+
+	if c.state == common.EPollOUT {
+		return c.poller.ModWrite(c.fd, int(c.id))
+	}
+	if atomic.LoadInt32(&c.userPaused) == 1 {
+		return c.poller.PauseRead(c.fd, int(c.id))
+	}
+	return nil
+}
+
+//ReadPaused 是否调用了PauseRead
+func (c *BaseConnect) ReadPaused() bool {
+	return atomic.LoadInt32(&c.userPaused) == 1
+}
+
+//CloseWrite 只关闭写端(SHUT_WR)，对端会读取到EOF，连接仍然可以继续读取，直到对端关闭
+//写入队列中还有数据时，会在发送完毕后再关闭写端
+func (c *BaseConnect) CloseWrite() error {
+
+	// TLS需要先发送close_notify
+	if c.GetTLSEnable() && c.GetHandshakeCompleted() {
+		_ = c.tlsLayer.CloseWrite()
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&c.writeClosed, 0, 1) {
+		return nil
+	}
+
+	if c.state == common.EPollOUT {
+		return nil
+	}
+	return unix.Shutdown(c.fd, unix.SHUT_WR)
+}
+
+//NextSeq 生成下一个序列号，从1开始，开启Options.Sequence后发送的每个数据包都会使用一个序列号
+func (c *BaseConnect) NextSeq() uint64 {
+	return atomic.AddUint64(&c.sendSeq, 1)
+}
+
+//pack 封包，开启Options.Sequence时在头部写入序列号
+func (c *BaseConnect) pack(msgID uint32, bs []byte) ([]byte, error) {
+	packer := c.GetPacker()
+	if c.options.Sequence {
+		if seqPacker, ok := packer.(iface.ISeqPacker); ok {
+			return seqPacker.PackWithSeq(msgID, c.NextSeq(), bs)
+		}
+	}
+	return packer.Pack(msgID, bs)
+}
+
+//isReplay 收到的序列号是否重复
+func (c *BaseConnect) isReplay(message iface.IMessage) bool {
+	if c.seqWindow == nil {
+		return false
+	}
+	if m, ok := message.(iface.ISeqMessage); ok && !c.seqWindow.Check(m.Seq()) {
+		if m.Seq() == 0 {
+			c.options.Logger.Warnf("drop msgID[%d] without seq of connID[%d]", message.ID(), c.id)
+		} else {
+			c.options.Logger.Warnf("drop replayed seq[%d] msgID[%d] of connID[%d]", m.Seq(), message.ID(), c.id)
+		}
+		return true
+	}
+	return false
+}
+
+//sequenced 是否开启了Options.Sequence，发送的每个数据包都需要使用这个连接的序列号
+func (c *BaseConnect) sequenced() bool {
+	return c.options.Sequence
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+//BenchmarkDispatch 流水线发送小包，对比逐个投递和批量投递的吞吐量
+func BenchmarkDispatch(b *testing.B) {
+	for _, batch := range []bool{false, true} {
+		name := "default"
+		if batch {
+			name = "batch"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := startServer(b, WithBatchDispatch(batch))
+			s.AddRouter(1, new(echoRouter))
+			conn := dial(b, s)
+
+			frame := packFrame(b, 1, []byte("ping"))
+			chunk := bytes.Repeat(frame, 64)
+
+			errCh := make(chan error, 1)
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+
+			go func() {
+				for sent := 0; sent < b.N; sent += 64 {
+					data := chunk
+					if remain := b.N - sent; remain < 64 {
+						data = chunk[:remain*len(frame)]
+					}
+					if _, err := conn.Write(data); err != nil {
+						errCh <- err
+						return
+					}
+				}
+				errCh <- nil
+			}()
+
+			// 回复和请求的长度相同
+			_ = conn.SetReadDeadline(time.Now().Add(time.Minute))
+			if _, err := io.CopyN(io.Discard, bufio.NewReader(conn), int64(b.N*len(frame))); err != nil {
+				b.Fatal(err)
+			}
+			if err := <-errCh; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//packetWriter 可以直接发送封包好的数据，避免给每个连接重复封包
+type packetWriter interface {
+	writePacket(dataPack []byte) (int, error)
+}
+
+//customPacker 连接调用过SetPacker时返回单独的packer
+type customPacker interface {
+	customPacker() (iface.IPacker, bool)
+}
+
+//sequencedConnect 开启了Options.Sequence的连接，每个数据包都有这个连接递增的序列号
+type sequencedConnect interface {
+	sequenced() bool
+	pack(msgID uint32, bs []byte) ([]byte, error)
+}
+
+//broadcast 只封包一次，然后发送给所有连接，返回每个发送失败的连接
+//开启了序列号、调用过SetPacker的连接需要单独封包
+func broadcast(packer iface.IPacker, msgID uint32, data []byte, connects []iface.IConnect) error {
+
+	dataPack, err := packer.Pack(msgID, data)
+	if err != nil {
+		return err
+	}
+
+	failed := make(util.BroadcastError)
+	for _, connect := range connects {
+		writer, ok := connect.(packetWriter)
+		if !ok {
+			failed[connect.GetID()] = util.ApplicationNotRouterMode
+			continue
+		}
+
+		// 开启了序列号时使用这个连接的序列号，接收方可以排序、去重，调用过SetPacker的连接也需要单独封包
+		packet := dataPack
+		if seq, ok := connect.(sequencedConnect); ok && seq.sequenced() {
+			if packet, err = seq.pack(msgID, data); err != nil {
+				failed[connect.GetID()] = err
+				continue
+			}
+		} else if custom, ok := connect.(customPacker); ok {
+			if connPacker, ok := custom.customPacker(); ok {
+				if packet, err = connPacker.Pack(msgID, data); err != nil {
+					failed[connect.GetID()] = err
+					continue
+				}
+			}
+		}
+
+		if _, err := writer.writePacket(packet); err != nil {
+			failed[connect.GetID()] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/eventloop"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
+)
+
+//Client 主动连接其他服务，和Server使用相同的封包解包、路由、中间件以及事件循环，可以处理对端主动推送的消息
+//仅支持路由模式，暂不支持TLS
+type Client struct {
+	address    string
+	options    *Options              // 可选项参数
+	eventloop  iface.IEventLoop      // 事件循环
+	connectMgr iface.IConnectManager // 连接管理，同时只会有一个连接
+	routerMgr  *RouterMgr            // 路由统一管理
+	emitCh     chan iface.IContext   // 从这里接收epoll转发过来的消息
+	connect    iface.IConnect        // 当前的连接，未连接时为nil
+	lock       sync.RWMutex          //
+	closed     int32                 // 是否已调用Close
+	cancel     context.CancelFunc    // 取消客户端的context
+	dial       dialFunc              // 创建连接，默认为dialSocket，TestServer使用socketpair
+}
+
+//NewClient 创建客户端，此时还未连接，添加路由后调用Connect，连接后对端立即推送的消息也可以被处理
+func NewClient(address string, opts ...Option) (*Client, error) {
+
+	options := parseOption(opts...)
+	options.Application = common.RouterMode
+
+	// 只有一个连接，一个事件循环就够了
+	if options.NumEventLoop <= 0 {
+		options.NumEventLoop = 1
+	}
+
+	if err := prepareOption(options); err != nil {
+		return nil, err
+	}
+
+	if options.TlsEnable {
+		return nil, util.ClientTLSNotSupported
+	}
+
+	// 客户端的生命周期
+	ctx, cancel := context.WithCancel(options.ctx)
+	options.ctx = ctx
+
+	client := &Client{
+		address:    address,
+		options:    options,
+		eventloop:  eventloop.NewEventLoop(options.NumEventLoop, options.LoopBalancer, options.Logger),
+		connectMgr: newConnectManager(options, newConnectGroupMgr(options.Packer)),
+		routerMgr:  NewRouterMgr(),
+		emitCh:     make(chan iface.IContext, options.EmitChanSize),
+		cancel:     cancel,
+	}
+
+	if err := client.eventloop.Init(client.connectMgr); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if loop, ok := client.eventloop.(*eventloop.EventLoop); ok {
+		loop.EmitPolicy = options.EmitPolicy
+		loop.EdgeTriggered = options.EpollEdgeTriggered
+		loop.BatchDispatch = options.BatchDispatch
+		loop.WaitTimeout = options.EpollWaitTimeout
+		loop.OnError = options.OnError
+	}
+
+	client.eventloop.Start(client.emitCh)
+	go client.doMessage()
+
+	return client, nil
+}
+
+//Dial 创建客户端并连接，连接超时通过WithDialTimeout设置
+//返回*Client而不是iface.IConnect：重连后连接会被替换，需要通过Conn获取当前的连接，添加路由、关闭事件循环也都在Client上
+//对端连接后立即推送的消息需要先添加路由，这种情况使用NewClient，添加路由后再调用Connect
+func Dial(address string, opts ...Option) (*Client, error) {
+
+	client, err := NewClient(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Connect(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+//Connect 连接对端，已有连接时会先关闭，断开后也可以再次调用重新连接，每次连接成功都会执行OnConnect
+func (c *Client) Connect() error {
+
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return util.ClientClosed
+	}
+
+	// 关闭旧的连接
+	c.lock.Lock()
+	old := c.connect
+	c.connect = nil
+	c.lock.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+
+	fd, sa, err := c.dialFn()
+	if err != nil {
+		return err
+	}
+
+	// 每次连接都会生成新的ID
+	baseConnect := newBaseConnect(c.options.nextConnID(), fd, util.SockaddrToTCPOrUnixAddr(sa), c.options)
+	connect := newRouterProtocol(baseConnect)
+
+	// 同acceptor.handle，先分配事件循环、加入管理并执行OnConnect，之后再注册读事件
+	// 对端连接成功后立即推送的数据，不会因为事件循环还找不到这个连接而被关闭
+	c.eventloop.Attach(connect)
+	c.connectMgr.Add(connect)
+
+	c.lock.Lock()
+	c.connect = connect
+	c.lock.Unlock()
+
+	// Connect和Close同时调用时，不能留下未关闭的连接
+	if atomic.LoadInt32(&c.closed) == 1 {
+		_ = connect.Close()
+		return util.ClientClosed
+	}
+
+	if c.options.OnConnect != nil {
+		c.options.OnConnect(connect)
+	}
+
+	// 添加事件循环，OnConnect中关闭了连接时返回util.ConnectClosed
+	if err := baseConnect.admit(c.eventloop, connect); err != nil {
+		if err != util.ConnectClosed {
+			_ = connect.Close()
+		}
+		return err
+	}
+
+	// 断开后自动重连
+	if c.options.Reconnect.enabled() {
+		go c.watch(connect)
+	}
+	return nil
+}
+
+//dialFn 创建连接的方法
+func (c *Client) dialFn() (int, unix.Sockaddr, error) {
+	if c.dial != nil {
+		return c.dial()
+	}
+	return dialSocket(c.address, c.options)
+}
+
+//Conn 当前的连接，未连接时返回nil
+func (c *Client) Conn() iface.IConnect {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.connect
+}
+
+//Send 发送消息，未连接时返回util.ClientNotConnected
+func (c *Client) Send(msgID uint32, bs []byte) (int, error) {
+	connect := c.Conn()
+	if connect == nil {
+		return 0, util.ClientNotConnected
+	}
+	return connect.Send(msgID, bs)
+}
+
+//AddRouter 添加路由，处理对端发送过来的消息
+func (c *Client) AddRouter(msgID uint32, router iface.IRouter) {
+	c.routerMgr.Add(msgID, router)
+}
+
+//RemoveRouter 删除路由
+func (c *Client) RemoveRouter(msgID uint32) {
+	c.routerMgr.Remove(msgID)
+}
+
+//AddResponseRouter 添加带响应对象的路由
+func (c *Client) AddResponseRouter(msgID uint32, router iface.IResponseRouter) {
+	c.AddRouter(msgID, ResponseRouter(router))
+}
+
+//AddContextRouter 添加带context的路由，连接关闭或客户端关闭时ctx会被取消
+func (c *Client) AddContextRouter(msgID uint32, router iface.IContextRouter) {
+	c.AddRouter(msgID, ContextRouter(router))
+}
+
+//Use 全局中间件
+func (c *Client) Use(callable iface.MiddlewareFunc, more ...iface.MiddlewareFunc) *Client {
+	c.routerMgr.globalMiddlewares = append(c.routerMgr.globalMiddlewares, callable)
+	c.routerMgr.globalMiddlewares = append(c.routerMgr.globalMiddlewares, more...)
+	return c
+}
+
+//doMessage 处理消息
+func (c *Client) doMessage() {
+	for {
+		select {
+		case <-c.options.ctx.Done():
+			return
+		case context := <-c.emitCh:
+
+			// 批量投递的消息在一个协程中按顺序处理
+			if batch, ok := context.(*util.Batch); ok {
+				go c.routerMgr.DispatchBatch(batch, c.options)
+				continue
+			}
+
+			// 心跳的pong不需要分发到路由
+			if c.options.Heartbeat.isPong(context) {
+				continue
+			}
+
+			go func(ctx iface.IContext) {
+				defer atomic.AddUint64(&c.options.counters.messages, 1)
+				c.routerMgr.Dispatch(ctx, c.options)
+			}(context)
+		}
+	}
+}
+
+//Close 关闭连接以及事件循环，关闭后不能再次连接
+func (c *Client) Close() {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+
+	c.cancel()
+	c.connectMgr.ClearAll()
+	c.eventloop.Stop()
+
+	c.lock.Lock()
+	c.connect = nil
+	c.lock.Unlock()
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//closeRouter 在handler中关闭自己的连接
+type closeRouter struct{}
+
+func (*closeRouter) Do(request iface.IRequest) {
+	_ = request.GetConnect().Close()
+}
+
+func TestConcurrentClose(t *testing.T) {
+	const (
+		connects   = 20
+		goroutines = 8
+	)
+
+	var closeCount int64
+	connected := make(chan iface.IConnect, connects)
+	closed := make(chan uint64, connects*goroutines)
+	s := startServer(t,
+		WithOnConnect(func(connect iface.IConnect) {
+			connected <- connect
+		}),
+		WithOnClose(func(connect iface.IConnect) {
+			atomic.AddInt64(&closeCount, 1)
+			closed <- connect.GetID()
+		}),
+	)
+	s.AddRouter(1, new(closeRouter))
+
+	conns := make([]iface.IConnect, 0, connects)
+	for i := 0; i < connects; i++ {
+		conn := dial(t, s)
+		select {
+		case connect := <-connected:
+			conns = append(conns, connect)
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect not called")
+		}
+
+		// 对端关闭（事件循环）和handler中关闭，与下面的关闭同时进行
+		if i%2 == 0 {
+			_, _ = conn.Write(packFrame(t, 1, []byte("close")))
+		} else {
+			_ = conn.Close()
+		}
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for _, connect := range conns {
+				_ = connect.Close()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		s.connectMgr.ClearAll()
+	}()
+	close(start)
+	wg.Wait()
+
+	waitFor(t, time.Second, func() bool {
+		return atomic.LoadInt64(&closeCount) >= connects
+	})
+
+	// 等待可能重复的回调
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&closeCount); n != connects {
+		t.Fatalf("OnClose called %d times for %d connections", n, connects)
+	}
+
+	seen := make(map[uint64]bool, connects)
+	for i := 0; i < connects; i++ {
+		id := <-closed
+		if seen[id] {
+			t.Fatalf("OnClose called twice for connection %d", id)
+		}
+		seen[id] = true
+	}
+	if s.connectMgr.Len() != 0 {
+		t.Fatalf("%d connections left in the manager", s.connectMgr.Len())
+	}
+}
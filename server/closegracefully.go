@@ -0,0 +1,79 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//queuedSender CloseGracefully期间不再接收新的发送，但异步发送队列中已有的消息仍需发送出去
+type queuedSender interface {
+	send(msgID uint32, bs []byte) (int, error)
+}
+
+//CloseGracefully 发送完异步发送队列、写入队列中的数据后再关闭，超时后强制关闭并返回util.CloseTimeout
+func (c *BaseConnect) CloseGracefully(timeout time.Duration) error {
+	return c.closeGracefully(c, timeout)
+}
+
+//CloseGracefully 发送完缓冲区、异步发送队列、写入队列中的数据后再关闭
+func (c *routerProtocol) CloseGracefully(timeout time.Duration) error {
+	return c.closeGracefully(c, timeout)
+}
+
+//CloseGracefully 发送完写入队列中的数据后再发送close帧
+func (c *websocketProtocol) CloseGracefully(timeout time.Duration) error {
+	return c.closeGracefully(c, timeout)
+}
+
+//CloseGracefully 发送完异步发送队列中的数据后再删除伪连接
+func (c *udpConnect) CloseGracefully(timeout time.Duration) error {
+	return c.closeGracefully(c, timeout)
+}
+
+//closeGracefully 不再接收新的发送，等待数据全部发送完毕后关闭，重复调用时直接返回
+func (c *BaseConnect) closeGracefully(connect iface.IConnect, timeout time.Duration) error {
+
+	if !atomic.CompareAndSwapInt32(&c.draining, 0, 1) {
+		return nil
+	}
+
+	// SendNoFlush缓冲的数据
+	_ = connect.Flush()
+
+	deadline := time.Now().Add(timeout)
+	for !c.writeDrained() {
+
+		// 已经被关闭，如：对端断开
+		if c.ctx.Err() != nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			_ = connect.Close()
+			return util.CloseTimeout
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	return connect.Close()
+}
+
+//isDraining 是否正在调用CloseGracefully
+func (c *BaseConnect) isDraining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+//writeDrained 异步发送队列、写入队列中的数据是否已全部发送
+func (c *BaseConnect) writeDrained() bool {
+	if atomic.LoadInt64(&c.asyncPending) > 0 {
+		return false
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.state != common.EPollOUT
+}
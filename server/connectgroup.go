@@ -0,0 +1,137 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//ConnectGroup 连接分组，可用于聊天室、游戏大厅、订阅主题等场景，成员由ConnectGroupMgr统一保存，可以长期持有
+type ConnectGroup struct {
+	name string
+	mgr  *ConnectGroupMgr
+}
+
+//ConnectGroupMgr 所有的连接分组
+type ConnectGroupMgr struct {
+	packer iface.IPacker
+	groups map[string]map[uint64]iface.IConnect // 分组名 => connID => Connect，最后一个连接离开时删除
+	joined map[uint64]map[string]struct{}       // connID => 加入的分组，LeaveAll只需要遍历这个连接的分组
+	sync.RWMutex
+}
+
+//closedConnect 可以判断是否已关闭的连接
+type closedConnect interface {
+	isClosed() bool
+}
+
+//newConnectGroupMgr .
+func newConnectGroupMgr(packer iface.IPacker) *ConnectGroupMgr {
+	return &ConnectGroupMgr{
+		packer: packer,
+		groups: make(map[string]map[uint64]iface.IConnect),
+		joined: make(map[uint64]map[string]struct{}),
+	}
+}
+
+//Get 获取分组，第一个连接加入时才会创建，最后一个连接离开后删除
+func (m *ConnectGroupMgr) Get(name string) *ConnectGroup {
+	return &ConnectGroup{
+		name: name,
+		mgr:  m,
+	}
+}
+
+//LeaveAll 连接关闭时，从加入过的分组中移除
+func (m *ConnectGroupMgr) LeaveAll(conn iface.IConnect) {
+	m.Lock()
+	defer m.Unlock()
+
+	for name := range m.joined[conn.GetID()] {
+		m.leave(name, conn.GetID())
+	}
+}
+
+//join 调用方需要持有锁
+func (m *ConnectGroupMgr) join(name string, conn iface.IConnect) {
+	connects, ok := m.groups[name]
+	if !ok {
+		connects = make(map[uint64]iface.IConnect)
+		m.groups[name] = connects
+	}
+	connects[conn.GetID()] = conn
+
+	names, ok := m.joined[conn.GetID()]
+	if !ok {
+		names = make(map[string]struct{})
+		m.joined[conn.GetID()] = names
+	}
+	names[name] = struct{}{}
+}
+
+//leave 调用方需要持有锁，分组、连接没有剩余的记录时一起删除
+func (m *ConnectGroupMgr) leave(name string, connID uint64) {
+	if connects, ok := m.groups[name]; ok {
+		delete(connects, connID)
+		if len(connects) == 0 {
+			delete(m.groups, name)
+		}
+	}
+
+	if names, ok := m.joined[connID]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(m.joined, connID)
+		}
+	}
+}
+
+//Name 分组名称
+func (g *ConnectGroup) Name() string {
+	return g.name
+}
+
+//Join 加入分组，连接已关闭时返回util.ConnectClosed
+func (g *ConnectGroup) Join(conn iface.IConnect) error {
+	g.mgr.Lock()
+	defer g.mgr.Unlock()
+
+	// 关闭时先标记再LeaveAll，持有锁时判断，不会在LeaveAll之后又加入已关闭的连接
+	if closed, ok := conn.(closedConnect); ok && closed.isClosed() {
+		return util.ConnectClosed
+	}
+	g.mgr.join(g.name, conn)
+	return nil
+}
+
+//Leave 离开分组
+func (g *ConnectGroup) Leave(conn iface.IConnect) {
+	g.mgr.Lock()
+	defer g.mgr.Unlock()
+	g.mgr.leave(g.name, conn.GetID())
+}
+
+//Count 分组中的连接数量
+func (g *ConnectGroup) Count() int {
+	g.mgr.RLock()
+	defer g.mgr.RUnlock()
+	return len(g.mgr.groups[g.name])
+}
+
+//GetConnects 获取分组中的所有连接
+func (g *ConnectGroup) GetConnects() []iface.IConnect {
+	g.mgr.RLock()
+	defer g.mgr.RUnlock()
+
+	connects := make([]iface.IConnect, 0, len(g.mgr.groups[g.name]))
+	for _, connect := range g.mgr.groups[g.name] {
+		connects = append(connects, connect)
+	}
+	return connects
+}
+
+//Broadcast 给分组中的所有连接推送消息，只会封包一次
+func (g *ConnectGroup) Broadcast(msgID uint32, data []byte) error {
+	return broadcast(g.mgr.packer, msgID, data, g.GetConnects())
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//groupRecords 分组管理中剩余的分组数量和连接数量
+func groupRecords(s *Server) (int, int) {
+	s.groupMgr.RLock()
+	defer s.groupMgr.RUnlock()
+	return len(s.groupMgr.groups), len(s.groupMgr.joined)
+}
+
+func TestConnectGroupRemovedWhenEmpty(t *testing.T) {
+	connected := make(chan iface.IConnect, 2)
+	s := startServer(t, WithOnConnect(func(connect iface.IConnect) {
+		connected <- connect
+	}))
+
+	var connects []iface.IConnect
+	for i := 0; i < 2; i++ {
+		dial(t, s)
+		select {
+		case connect := <-connected:
+			connects = append(connects, connect)
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect not called")
+		}
+	}
+
+	room := s.ConnectGroup("room")
+	for _, connect := range connects {
+		if err := room.Join(connect); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ConnectGroup(connect.GetAddress().String()).Join(connect); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if groups, joined := groupRecords(s); groups != 3 || joined != 2 {
+		t.Fatalf("%d groups, %d connects after join", groups, joined)
+	}
+
+	_ = connects[0].Close()
+	if count := room.Count(); count != 1 {
+		t.Fatalf("room has %d connects after one closed", count)
+	}
+	if groups, joined := groupRecords(s); groups != 2 || joined != 1 {
+		t.Fatalf("%d groups, %d connects after one closed", groups, joined)
+	}
+
+	// 最后一个连接离开后分组被删除，之前获取的分组仍然可以使用
+	room.Leave(connects[1])
+	_ = connects[1].Close()
+	if groups, joined := groupRecords(s); groups != 0 || joined != 0 {
+		t.Fatalf("%d groups, %d connects left after all closed", groups, joined)
+	}
+	if count := room.Count(); count != 0 {
+		t.Fatalf("deleted room has %d connects", count)
+	}
+}
+
+func TestConnectGroupJoinClosed(t *testing.T) {
+	const connects = 50
+
+	connected := make(chan iface.IConnect, connects)
+	s := startServer(t, WithOnConnect(func(connect iface.IConnect) {
+		connected <- connect
+	}))
+
+	// 加入和关闭同时进行，关闭后的连接不能留在分组中
+	var wg sync.WaitGroup
+	for i := 0; i < connects; i++ {
+		dial(t, s)
+		var connect iface.IConnect
+		select {
+		case connect = <-connected:
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect not called")
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = connect.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			_ = s.ConnectGroup("room").Join(connect)
+		}()
+	}
+	wg.Wait()
+
+	if groups, joined := groupRecords(s); groups != 0 || joined != 0 {
+		t.Fatalf("%d groups, %d connects left after all closed", groups, joined)
+	}
+
+	// 已关闭的连接加入时返回错误
+	dial(t, s)
+	select {
+	case connect := <-connected:
+		_ = connect.Close()
+		if err := s.ConnectGroup("room").Join(connect); err != util.ConnectClosed {
+			t.Fatalf("join a closed connect got %v, want util.ConnectClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called")
+	}
+	if count := s.ConnectGroup("room").Count(); count != 0 {
+		t.Fatalf("room has %d connects", count)
+	}
+}
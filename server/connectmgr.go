@@ -1,31 +1,40 @@
 package server
 
 import (
+	"net"
 	"sync"
 	"time"
 
-	"golang.org/x/sys/unix"
-
+	"github.com/ikilobyte/netman/common"
 	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
 )
 
 //ConnectManager 所有连接都保存在这里
 type ConnectManager struct {
-	connects map[int]iface.IConnect // connFD => Connect
+	connects map[int]iface.IConnect    // connFD => Connect
+	ids      map[uint64]iface.IConnect // connID => Connect
+	ips      map[string]int            // ip => 连接数量
+	groups   *ConnectGroupMgr          // 连接分组
 	options  *Options
 	sync.RWMutex
 }
 
 //newConnectManager 构造一个实例
-func newConnectManager(options *Options) *ConnectManager {
+func newConnectManager(options *Options, groups *ConnectGroupMgr) *ConnectManager {
 
 	mgr := &ConnectManager{
 		connects: map[int]iface.IConnect{},
+		ids:      map[uint64]iface.IConnect{},
+		ips:      map[string]int{},
+		groups:   groups,
 		options:  options,
 	}
 
 	// 心跳检测
 	go mgr.HeartbeatCheck()
+	go mgr.PingCheck()
+	go mgr.SlowClientCheck()
 
 	return mgr
 }
@@ -35,6 +44,10 @@ func (c *ConnectManager) Add(conn iface.IConnect) int {
 	c.Lock()
 	defer c.Unlock()
 	c.connects[conn.GetFd()] = conn
+	c.ids[conn.GetID()] = conn
+	if ip := util.AddrIP(conn.GetAddress()); ip != "" {
+		c.ips[ip] += 1
+	}
 	return len(c.connects)
 }
 
@@ -49,15 +62,77 @@ func (c *ConnectManager) Get(connFD int) iface.IConnect {
 	return nil
 }
 
+//GetByID 通过连接ID获取连接实例
+func (c *ConnectManager) GetByID(connID uint64) (iface.IConnect, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	conn, ok := c.ids[connID]
+	return conn, ok
+}
+
 //Remove 删除一个连接
 func (c *ConnectManager) Remove(conn iface.IConnect) {
+
+	// 所有关闭连接的路径都会执行到这里，从所有分组中移除
+	c.groups.LeaveAll(conn)
+
 	c.Lock()
 	defer c.Unlock()
-	delete(c.connects, conn.GetFd())
+	c.remove(conn)
+}
+
+//remove 从所有索引中删除，调用方需要持有锁，重复删除不会有影响
+func (c *ConnectManager) remove(conn iface.IConnect) {
+	if _, ok := c.ids[conn.GetID()]; !ok {
+		return
+	}
+
+	// fd可能已经被新的连接复用，只删除同一个连接
+	if c.connects[conn.GetFd()] == conn {
+		delete(c.connects, conn.GetFd())
+	}
+	delete(c.ids, conn.GetID())
+
+	if ip := util.AddrIP(conn.GetAddress()); ip != "" {
+		if c.ips[ip] -= 1; c.ips[ip] <= 0 {
+			delete(c.ips, ip)
+		}
+	}
+}
+
+//readdress 连接的对端地址发生了变化(PROXY protocol)，同时更新ip的连接数
+func (c *ConnectManager) readdress(conn *BaseConnect, address net.Addr) {
+	c.Lock()
+	defer c.Unlock()
+
+	// 还未添加到连接管理中，添加时会使用新的地址
+	if _, ok := c.ids[conn.GetID()]; !ok {
+		conn.Address = address
+		return
+	}
+
+	if ip := util.AddrIP(conn.Address); ip != "" {
+		if c.ips[ip] -= 1; c.ips[ip] <= 0 {
+			delete(c.ips, ip)
+		}
+	}
+	conn.Address = address
+	if ip := util.AddrIP(address); ip != "" {
+		c.ips[ip] += 1
+	}
+}
+
+//CountByIP 获取这个ip的连接数量
+func (c *ConnectManager) CountByIP(ip string) int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ips[ip]
 }
 
 //Len 获取有多少个连接
 func (c *ConnectManager) Len() int {
+	c.RLock()
+	defer c.RUnlock()
 	return len(c.connects)
 }
 
@@ -67,66 +142,89 @@ func (c *ConnectManager) ClearByEpFd(epfd int) {
 
 	// TODO 待优化
 	c.Lock()
-	defer c.Unlock()
-
-	for connID, connect := range c.connects {
+	connects := make([]iface.IConnect, 0)
+	for _, connect := range c.connects {
 		if connect.GetEpFd() != epfd {
 			continue
 		}
 
-		// 删除事件监听
-		_ = connect.GetPoller().Remove(connect.GetFd())
+		connects = append(connects, connect)
+	}
 
-		// 断开连接
-		_ = unix.Close(connect.GetFd())
+	// 从所有连接中删除
+	for _, connect := range connects {
+		c.remove(connect)
+	}
+	c.Unlock()
 
-		// 从所有连接中删除
-		delete(c.connects, connID)
+	// Close中会调用Remove，不能在持有锁的时候关闭，会执行OnClose回调
+	for _, connect := range connects {
+		_ = connect.Close()
 	}
 }
 
 //ClearAll 清除所有连接
 func (c *ConnectManager) ClearAll() {
 	c.Lock()
-	defer c.Unlock()
+	connects := c.connects
+	c.connects = make(map[int]iface.IConnect)
+	c.ids = make(map[uint64]iface.IConnect)
+	c.ips = make(map[string]int)
+	c.Unlock()
 
-	for _, connect := range c.connects {
-		_ = connect.Close()
+	// Close中会调用Remove，不能在持有锁的时候关闭
+	for _, connect := range connects {
+		_ = closeWith(connect, common.CloseShutdown)
 	}
-	c.connects = make(map[int]iface.IConnect)
 }
 
 //HeartbeatCheck 心跳检测
 func (c *ConnectManager) HeartbeatCheck() {
 
-	if int(c.options.HeartbeatCheckInterval) <= 0 || int(c.options.HeartbeatIdleTime) < 0 {
+	if int(c.options.HeartbeatCheckInterval) <= 0 || int(c.options.HeartbeatIdleTime) <= 0 {
 		return
 	}
 
-	idleTime := int64(c.options.HeartbeatIdleTime / time.Second)
+	ticker := time.NewTicker(c.options.HeartbeatCheckInterval)
+	defer ticker.Stop()
 
-	// 在遍历所有连接时，不会加锁，不能影响到正常操作，但可能会有
 	for {
 		select {
-		case now := <-time.Tick(c.options.HeartbeatCheckInterval):
-			for _, connect := range c.connects {
-				lastMessageTime := connect.GetLastMessageTime()
-				if now.Unix()-lastMessageTime.Unix() < idleTime {
+		case <-c.options.ctx.Done():
+			return
+		case now := <-ticker.C:
+			// 遍历的是快照，关闭连接时会修改connects
+			for _, connect := range c.GetConnects() {
+				if now.Sub(connect.GetLastMessageTime()) < c.options.HeartbeatIdleTime {
 					continue
 				}
 
 				// 强制断开连接，会正常执行OnClose回调
-				_ = connect.Close()
-				c.Remove(connect)
-				_ = connect.GetPoller().Remove(connect.GetFd())
+				_ = closeWith(connect, common.CloseIdleTimeout)
 			}
 		}
 	}
 }
 
+//Range 遍历所有连接，callable返回false时停止
+//遍历的是快照，不持有锁，callable中可以关闭连接；遍历过程中已关闭的连接会被跳过
+func (c *ConnectManager) Range(callable func(conn iface.IConnect) bool) {
+	for _, connect := range c.GetConnects() {
+		if _, ok := c.GetByID(connect.GetID()); !ok {
+			continue
+		}
+		if !callable(connect) {
+			return
+		}
+	}
+}
+
 //GetConnects 获取所有连接
 func (c *ConnectManager) GetConnects() []iface.IConnect {
-	connects := make([]iface.IConnect, 0, c.Len())
+	c.RLock()
+	defer c.RUnlock()
+
+	connects := make([]iface.IConnect, 0, len(c.connects))
 	for _, connect := range c.connects {
 		connects = append(connects, connect)
 	}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"runtime/debug"
+
+	"github.com/ikilobyte/netman/util"
+)
+
+//Go 在连接专属的协程中按提交顺序依次执行task，同一个连接的异步任务不会并发执行，在任务中发送的结果会保持顺序
+//没有任务时协程会退出，连接关闭后返回util.ConnectClosed，已经提交的任务仍然会执行完，任务中可以通过Context()判断连接是否已关闭
+func (c *BaseConnect) Go(task func()) error {
+	if c.ctx.Err() != nil {
+		return util.ConnectClosed
+	}
+
+	c.taskLock.Lock()
+	defer c.taskLock.Unlock()
+
+	c.tasks = append(c.tasks, task)
+	if c.taskRunning {
+		return nil
+	}
+	c.taskRunning = true
+	go c.runTasks()
+	return nil
+}
+
+//runTasks 依次执行队列中的任务，队列为空时退出
+func (c *BaseConnect) runTasks() {
+	for {
+		c.taskLock.Lock()
+		if len(c.tasks) == 0 {
+			c.taskRunning = false
+			c.taskLock.Unlock()
+			return
+		}
+		task := c.tasks[0]
+		c.tasks[0] = nil
+		c.tasks = c.tasks[1:]
+		c.taskLock.Unlock()
+
+		c.runTask(task)
+	}
+}
+
+//runTask 单个任务的panic不能影响之后的任务
+func (c *BaseConnect) runTask(task func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.options.Logger.WithFields(map[string]interface{}{
+				"connID": c.id,
+				"stack":  string(debug.Stack()),
+			}).Errorf("connect task panic: %v", recovered)
+		}
+	}()
+	task()
+}
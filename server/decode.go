@@ -0,0 +1,16 @@
+package server
+
+import "github.com/ikilobyte/netman/iface"
+
+//bodyDecoder 包体已加密、压缩的消息，读取完包体后解密、解压
+type bodyDecoder interface {
+	Decode() error
+}
+
+//decodeBody 解密、解压包体，自定义的IMessage不处理
+func decodeBody(message iface.IMessage) error {
+	if m, ok := message.(bodyDecoder); ok {
+		return m.Decode()
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"time"
+
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
+)
+
+//dialFunc 创建连接，返回已连接的非阻塞fd和对端地址
+type dialFunc = func() (int, unix.Sockaddr, error)
+
+//dialSocket 使用非阻塞的方式连接对端，timeout <= 0 表示不限制连接时间，返回已连接的非阻塞fd
+func dialSocket(address string, options *Options) (int, unix.Sockaddr, error) {
+
+	// 解析地址，IPv4或IPv6
+	domain, sa, err := resolveListenAddr("tcp", address, false)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+	if err != nil {
+		return -1, nil, err
+	}
+	unix.CloseOnExec(fd)
+
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return -1, nil, err
+	}
+
+	// 非阻塞模式下会返回EINPROGRESS，等待可写后通过SO_ERROR获取连接结果
+	if err := unix.Connect(fd, sa); err != nil && err != unix.EINPROGRESS {
+		_ = unix.Close(fd)
+		return -1, nil, err
+	}
+
+	if err := waitConnected(fd, options.DialTimeout); err != nil {
+		_ = unix.Close(fd)
+		return -1, nil, err
+	}
+
+	// 设置属性
+	if secs := int(options.TCPKeepAlive / time.Second); secs >= 1 {
+		if err := setKeepAlive(fd, secs); err != nil {
+			_ = unix.Close(fd)
+			return -1, nil, err
+		}
+	}
+
+	if err := setNoDelay(fd, options.TCPNoDelay); err != nil {
+		_ = unix.Close(fd)
+		return -1, nil, err
+	}
+
+	if err := setSocketBuffer(fd, options.SocketRecvBuffer, options.SocketSendBuffer); err != nil {
+		_ = unix.Close(fd)
+		return -1, nil, err
+	}
+
+	peer, err := unix.Getpeername(fd)
+	if err != nil {
+		_ = unix.Close(fd)
+		return -1, nil, err
+	}
+
+	return fd, peer, nil
+}
+
+//waitConnected 等待连接完成
+func waitConnected(fd int, timeout time.Duration) error {
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		ms := -1
+		if !deadline.IsZero() {
+			remain := time.Until(deadline)
+			if remain <= 0 {
+				return util.DialTimeout
+			}
+			ms = int(remain / time.Millisecond)
+			if ms <= 0 {
+				ms = 1
+			}
+		}
+
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+		n, err := unix.Poll(fds, ms)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+
+		// 超时
+		if n == 0 {
+			continue
+		}
+
+		// 连接结果
+		code, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return unix.Errno(code)
+		}
+		return nil
+	}
+}
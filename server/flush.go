@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/ikilobyte/netman/util"
+)
+
+//SendNoFlush 仅路由模式可用
+func (c *BaseConnect) SendNoFlush(msgID uint32, bs []byte) error {
+	return util.ApplicationNotRouterMode
+}
+
+//Flush 仅路由模式可用
+func (c *BaseConnect) Flush() error {
+	return util.ApplicationNotRouterMode
+}
+
+//SendNoFlush 封包后先保存在缓冲区中，调用Flush时合并为一次写入，适合连续发送大量小包
+func (c *routerProtocol) SendNoFlush(msgID uint32, bs []byte) error {
+
+	if c.isDraining() {
+		return util.ConnectClosing
+	}
+
+	dataPack, err := c.pack(msgID, bs)
+	if err != nil {
+		return err
+	}
+
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+	c.pending = append(c.pending, dataPack)
+	return nil
+}
+
+//Flush 将SendNoFlush缓冲的数据包一次性发送出去
+func (c *routerProtocol) Flush() error {
+
+	c.pendingLock.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.pendingLock.Unlock()
+
+	if len(pending) <= 0 {
+		return nil
+	}
+
+	// websocket客户端每一帧只能包含一个数据包
+	if c.ws != nil {
+		for _, dataPack := range pending {
+			if _, err := c.writePacket(dataPack); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	_, err := c.writePacket(bytes.Join(pending, nil))
+	return err
+}
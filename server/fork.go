@@ -0,0 +1,69 @@
+package server
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+//ListenerFDEnv Fork时通过这个环境变量告诉新进程继承的监听fd
+const ListenerFDEnv = "NETMAN_LISTENER_FD"
+
+//Fork 使用相同的参数启动新的进程，并把监听的fd传递过去，新进程通过InheritedFD获取后调用NewFromFD继续接收新连接
+//启动新进程之前当前进程会暂停接收新连接，新连接留在内核的accept队列中由新进程接收，启动失败时恢复
+//新进程启动后，当前进程调用Shutdown等待已有的消息处理完毕后退出，即可实现不停机重启
+func (s *Server) Fork() (*os.Process, error) {
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	// 复制一份，由新进程继承，当前进程Shutdown关闭监听fd后不影响新进程
+	// 复制的fd也需要close-on-exec，新进程只通过ExtraFiles中的fd 3继承
+	fd, err := unix.FcntlInt(uintptr(s.socket.fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	listener := os.NewFile(uintptr(fd), "netman-listener")
+	defer listener.Close()
+
+	// ExtraFiles中的第一个fd在新进程中是3
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, item := range os.Environ() {
+		if !strings.HasPrefix(item, ListenerFDEnv+"=") {
+			env = append(env, item)
+		}
+	}
+	env = append(env, ListenerFDEnv+"=3")
+
+	if err := s.PauseAccept(); err != nil {
+		return nil, err
+	}
+
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr, listener},
+	})
+	if err != nil {
+		_ = s.ResumeAccept()
+		return nil, err
+	}
+	return process, nil
+}
+
+//InheritedFD 获取Fork传递过来的监听fd，不是通过Fork启动时返回false
+func InheritedFD() (int, bool) {
+	value := os.Getenv(ListenerFDEnv)
+	if value == "" {
+		return 0, false
+	}
+
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 0 {
+		return 0, false
+	}
+	return fd, true
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//forkOutputEnv Fork启动的测试进程把继承到的socket写入这个文件后退出
+const forkOutputEnv = "NETMAN_FORK_TEST_OUTPUT"
+
+func TestMain(m *testing.M) {
+	if output := os.Getenv(forkOutputEnv); output != "" {
+		os.Exit(reportInheritedSockets(output))
+	}
+	os.Exit(m.Run())
+}
+
+//reportInheritedSockets 记录当前进程打开的所有socket的fd
+func reportInheritedSockets(output string) int {
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return 1
+	}
+
+	var sockets []string
+	for _, entry := range entries {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		var stat unix.Stat_t
+		if unix.Fstat(fd, &stat) == nil && stat.Mode&unix.S_IFMT == unix.S_IFSOCK {
+			sockets = append(sockets, entry.Name())
+		}
+	}
+	if err := ioutil.WriteFile(output, []byte(strings.Join(sockets, ",")), 0644); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func TestForkNoInheritedConnections(t *testing.T) {
+	const connects = 5
+
+	connected := make(chan iface.IConnect, connects)
+	s := startServer(t, WithOnConnect(func(connect iface.IConnect) {
+		connected <- connect
+	}))
+	for i := 0; i < connects; i++ {
+		dial(t, s)
+		select {
+		case <-connected:
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect not called")
+		}
+	}
+
+	output := filepath.Join(t.TempDir(), "sockets")
+	if err := os.Setenv(forkOutputEnv, output); err != nil {
+		t.Fatal(err)
+	}
+	process, err := s.Fork()
+	_ = os.Unsetenv(forkOutputEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := process.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Success() {
+		t.Fatalf("forked process exited with %v", state)
+	}
+
+	// 只继承监听的fd 3
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "3" {
+		t.Fatalf("forked process has sockets [%s], want only the listener [3]", got)
+	}
+}
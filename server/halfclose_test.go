@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+)
+
+//countRouter 记录收到的消息数量
+type countRouter struct {
+	count int64
+}
+
+func (r *countRouter) Do(request iface.IRequest) {
+	atomic.AddInt64(&r.count, 1)
+}
+
+func TestPeerHalfClose(t *testing.T) {
+	for name, edgeTriggered := range map[string]bool{"LT": false, "ET": true} {
+		edgeTriggered := edgeTriggered
+		t.Run(name, func(t *testing.T) {
+			const frames = 50
+
+			router := new(countRouter)
+			closed := make(chan common.CloseReason, 1)
+			s := startServer(t,
+				WithEpollEdgeTriggered(edgeTriggered),
+				WithOnClose(func(connect iface.IConnect) {
+					closed <- connect.CloseReason()
+				}),
+			)
+			s.AddRouter(1, router)
+
+			// 最后几个包和FIN在同一次可读事件中到达
+			var buff bytes.Buffer
+			for i := 0; i < frames; i++ {
+				buff.Write(packFrame(t, 1, bytes.Repeat([]byte("x"), 100)))
+			}
+
+			conn := dial(t, s)
+			if _, err := conn.Write(buff.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case reason := <-closed:
+				if reason != common.ClosePeer {
+					t.Fatalf("close reason %v, want %v", reason, common.ClosePeer)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("OnClose not called after the peer shut down its write side")
+			}
+
+			waitFor(t, time.Second, func() bool {
+				return atomic.LoadInt64(&router.count) == frames
+			})
+		})
+	}
+}
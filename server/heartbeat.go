@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//Heartbeat 应用层心跳，服务端定时发送ping，超时未收到pong则关闭连接，仅路由模式可用
+type Heartbeat struct {
+	Interval time.Duration                     // 发送ping的间隔
+	Timeout  time.Duration                     // 发送ping后多长时间内必须收到pong
+	MakePing func() []byte                     // 生成ping数据，需要是已经封包好的数据
+	IsPong   func(message iface.IMessage) bool // 判断收到的消息是否为pong，pong不会分发到路由
+}
+
+//pinger 记录连接的ping/pong时间
+type pinger interface {
+	sentPing(now time.Time)
+	receivedPong(now time.Time)
+	pingState() (pingTime, pongTime int64)
+}
+
+//sentPing .
+func (c *BaseConnect) sentPing(now time.Time) {
+	atomic.StoreInt64(&c.pingTime, now.UnixNano())
+}
+
+//receivedPong .
+func (c *BaseConnect) receivedPong(now time.Time) {
+	atomic.StoreInt64(&c.pongTime, now.UnixNano())
+}
+
+//pingState .
+func (c *BaseConnect) pingState() (int64, int64) {
+	return atomic.LoadInt64(&c.pingTime), atomic.LoadInt64(&c.pongTime)
+}
+
+//isPong 是否为心跳的pong消息，是的话记录收到的时间
+func (h *Heartbeat) isPong(ctx iface.IContext) bool {
+	if h == nil || h.IsPong == nil || !h.IsPong(ctx.GetMessage()) {
+		return false
+	}
+
+	if connect, ok := ctx.GetConnect().(pinger); ok {
+		connect.receivedPong(time.Now())
+	}
+	return true
+}
+
+//PingCheck 应用层心跳检测
+func (c *ConnectManager) PingCheck() {
+
+	heartbeat := c.options.Heartbeat
+	if heartbeat == nil || heartbeat.Interval <= 0 || heartbeat.Timeout <= 0 || heartbeat.MakePing == nil {
+		return
+	}
+
+	// 检测的间隔，取二者中较小的那个
+	tick := heartbeat.Interval
+	if heartbeat.Timeout < tick {
+		tick = heartbeat.Timeout
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.options.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, connect := range c.GetConnects() {
+				state, ok := connect.(pinger)
+				if !ok {
+					continue
+				}
+				writer, ok := connect.(packetWriter)
+				if !ok {
+					continue
+				}
+
+				pingTime, pongTime := state.pingState()
+				elapsed := now.Sub(time.Unix(0, pingTime))
+
+				// 已发送ping，等待pong中
+				if pingTime > pongTime {
+					if elapsed >= heartbeat.Timeout {
+						c.options.Logger.Infof("connID[%d] heartbeat timeout", connect.GetID())
+						_ = closeWith(connect, common.CloseHeartbeatTimeout)
+					}
+					continue
+				}
+
+				if elapsed < heartbeat.Interval {
+					continue
+				}
+
+				state.sentPing(now)
+				_, err := writer.writePacket(heartbeat.MakePing())
+
+				// 已关闭写端的连接无法发送ping，不再等待pong，由对端关闭连接
+				if err == util.ConnectWriteClosed {
+					state.receivedPong(now)
+					continue
+				}
+
+				if err != nil {
+					_ = closeWith(connect, common.CloseWriteError)
+				}
+			}
+		}
+	}
+}
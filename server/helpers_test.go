@@ -0,0 +1,119 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//startServer 监听127.0.0.1的随机端口并启动，测试结束时Stop，并等待Start返回
+func startServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	return startServerOn(t, "127.0.0.1", 0, opts...)
+}
+
+//startServerOn 监听ip的port端口并启动，port为0时使用随机端口
+func startServerOn(t testing.TB, ip string, port int, opts ...Option) *Server {
+	t.Helper()
+
+	opts = append([]Option{WithLogOutput(io.Discard)}, opts...)
+	s, err := NewWithError(ip, port, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Start()
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("Start did not return after Stop")
+		}
+	})
+	return s
+}
+
+//dial 连接测试的Server，测试结束时关闭
+func dial(t testing.TB, s *Server) net.Conn {
+	t.Helper()
+	return dialAddr(t, s.Addr().String())
+}
+
+//dialAddr 连接指定的地址，测试结束时关闭
+func dialAddr(t testing.TB, address string) net.Conn {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+//packFrame 使用默认的封包方式封包
+func packFrame(t testing.TB, msgID uint32, data []byte) []byte {
+	t.Helper()
+
+	bs, err := util.NewDataPacker().Pack(msgID, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+//readFrame 读取一个默认封包方式的数据包
+func readFrame(conn net.Conn, timeout time.Duration) (iface.IMessage, error) {
+	return readFrameWith(conn, util.NewDataPacker(), timeout)
+}
+
+//readFrameWith 使用指定的packer读取一个数据包
+func readFrameWith(conn net.Conn, packer iface.IPacker, timeout time.Duration) (iface.IMessage, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	head := make([]byte, packer.GetHeaderLength())
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	message, err := packer.UnPack(head)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, message.Len())
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	message.SetData(body)
+	return message, nil
+}
+
+//echoRouter 原样返回收到的数据
+type echoRouter struct{}
+
+func (*echoRouter) Do(request iface.IRequest) {
+	_, _ = request.GetConnect().Send(request.GetMessage().ID(), request.GetMessage().Bytes())
+}
+
+//waitFor 等待cond返回true，超时后测试失败
+func waitFor(t testing.TB, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
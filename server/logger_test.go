@@ -0,0 +1,35 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ikilobyte/netman/util"
+)
+
+func TestLogOutputPerServer(t *testing.T) {
+	global := util.Logger.Out
+
+	var first, second bytes.Buffer
+	a := parseOption(WithLogOutput(&first))
+	b := parseOption(WithLogOutput(&second))
+	for _, options := range []*Options{a, b} {
+		if err := prepareOption(options); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a.Logger.Infof("from first")
+	b.Logger.Infof("from second")
+
+	if !strings.Contains(first.String(), "from first") || strings.Contains(first.String(), "from second") {
+		t.Fatalf("first output: %s", first.String())
+	}
+	if !strings.Contains(second.String(), "from second") || strings.Contains(second.String(), "from first") {
+		t.Fatalf("second output: %s", second.String())
+	}
+	if util.Logger.Out != global {
+		t.Fatal("WithLogOutput changed the global util.Logger output")
+	}
+}
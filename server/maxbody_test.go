@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+func TestMaxBodyLength(t *testing.T) {
+	router := new(countRouter)
+	errCh := make(chan error, 1)
+	closed := make(chan common.CloseReason, 1)
+	s := startServer(t,
+		WithMaxBodyLength(1024),
+		WithOnError(func(connect iface.IConnect, err error) {
+			errCh <- err
+		}),
+		WithOnClose(func(connect iface.IConnect) {
+			closed <- connect.CloseReason()
+		}),
+	)
+	s.AddRouter(1, router)
+
+	// 未超过限制的数据包正常处理
+	conn := dial(t, s)
+	if _, err := conn.Write(packFrame(t, 1, make([]byte, 1024))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool {
+		return atomic.LoadInt64(&router.count) == 1
+	})
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	// 长度字段为1GB，只发送头部
+	head := make([]byte, 8)
+	binary.LittleEndian.PutUint32(head, 1<<30)
+	binary.LittleEndian.PutUint32(head[4:], 1)
+	if _, err := conn.Write(head); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != util.BodyLenExceedLimit {
+			t.Fatalf("OnError got %v, want BodyLenExceedLimit", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnError not called for an oversized length header")
+	}
+	if reason := <-closed; reason != common.CloseReadError {
+		t.Fatalf("close reason %v, want %v", reason, common.CloseReadError)
+	}
+
+	// 连接已被关闭
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Fatalf("read after the oversized header returned %v, want the connection closed", err)
+	}
+
+	// 不会按长度字段分配包体
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= 1<<20 {
+		t.Fatalf("allocated %d bytes for a rejected header", allocated)
+	}
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
 	"log"
+	"net"
+	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/ikilobyte/netman/common"
 
 	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
 )
 
 //Options 可选项配置，未配置时使用默认值
@@ -18,8 +23,11 @@ type Options struct {
 	LogOutput              io.Writer               // 日志保存目标，默认：Stdout
 	Packer                 iface.IPacker           // 实现这个接口可以使用自定义的封包方式
 	TCPKeepAlive           time.Duration           // TCP keepalive
+	TCPNoDelay             bool                    // 是否设置TCP_NODELAY，默认：true
+	ReusePort              bool                    // 是否设置SO_REUSEPORT，多个进程可以监听同一个端口
+	ListenBacklog          int                     // listen的backlog，默认为系统的最大值
 	Hooks                  iface.IHooks            // hooks
-	MaxBodyLength          uint32                  // 包体部分最大长度，默认：0(不限制大小)
+	MaxBodyLength          uint32                  // 包体部分最大长度，默认：16MB，配置为0表示不限制大小
 	HeartbeatCheckInterval time.Duration           // 表示多久进行轮询一次心跳检测
 	HeartbeatIdleTime      time.Duration           // 连接最大允许空闲的时间，二者需要同时配置才会生效
 	TlsCertificate         *tls.Certificate        // tls证书
@@ -27,13 +35,92 @@ type Options struct {
 	TlsConfig              *tls.Config             // 自定义tls配置
 	WebsocketHandler       iface.IWebsocketHandler // websocket回调
 	Application            common.ApplicationMode  // 应用层协议类型
+	OnConnect              func(iface.IConnect)    // 连接加入管理后回调，在accept循环中同步执行，请勿阻塞
+	OnClose                func(iface.IConnect)    // 连接关闭后回调，每个连接只会执行一次
+	OnPanic                iface.PanicHandler      // 路由处理发生panic时回调，已recover，不会影响其它消息的处理
+	MaxConnections         int                     // 最大连接数，超过后新连接会被直接关闭，默认：0(不限制)
+	RejectPayload          []byte                  // 超过最大连接数时，关闭前发送给客户端的数据（需自行封包）
+	IdleTimeout            time.Duration           // 连接超过这个时间没有收到任何数据会被关闭，未配置HeartbeatCheckInterval时按IdleTimeout/2检测
+	WriteTimeout           time.Duration           // 单次写入超时时间，超时后Send返回util.WriteTimeout，默认：0(不限制)
+	MaxConnectionsPerIP    int                     // 同一个ip最大连接数，超过后新连接会被直接关闭，默认：0(不限制)
+	AllowedCIDRs           []string                // ip白名单，配置后只允许这些网段的连接
+	BlockedCIDRs           []string                // ip黑名单，这些网段的连接会被直接关闭
+	ipFilter               *util.IPFilter          // 解析后的黑白名单
+	Heartbeat              *Heartbeat              // 应用层心跳
+	counters               *counters               // 运行时计数器
+	ctx                    context.Context         // 服务的生命周期，所有连接的context都派生自这里
+	DualStack              bool                    // 双栈，监听所有网卡时同时接收IPv4和IPv6
+	WebsocketUpgrade       bool                    // 路由模式下同时支持websocket客户端
+	SendQueueSize          int                     // 异步发送队列长度，默认：1024
+	SendQueuePolicy        common.SendQueuePolicy  // 异步发送队列已满时的处理方式
+	LoopBalancer           iface.ILoopBalancer     // 新连接分配到事件循环的策略，默认：eventloop.RoundRobin()
+	ReadBufferSize         int                     // 读取包体时每次最多读取的字节数，默认：64KB
+	readPool               *util.BufferPool        // 读取时使用的buffer复用池
+	EmitChanSize           int                     // 事件循环投递消息的队列长度，默认：128
+	EmitPolicy             common.EmitPolicy       // 消息队列已满时的处理方式，默认阻塞
+	Logger                 iface.ILogger           // 日志，默认使用logrus，可以接入zap、zerolog等
+	HandlerTimeout         time.Duration           // 单条消息的处理超时时间，超时后取消请求的context，默认不限制
+	ReadRateLimit          int                     // 单个连接每秒最多读取的字节数，0表示不限制
+	ReadRateBurst          int                     // 单个连接允许突发读取的字节数，不能小于ReadRateLimit
+	NotFoundHandler        iface.IRouter           // 未匹配到路由时的处理方法
+	EpollEdgeTriggered     bool                    // 是否使用边缘触发(EPOLLET/EV_CLEAR)，默认水平触发
+	DialTimeout            time.Duration           // 客户端的连接超时时间，0表示不限制
+	Reconnect              *Reconnect              // 客户端断开后自动重连
+	Compression            iface.ICompressor       // 包体压缩，仅默认的封包方式支持，nil表示不压缩
+	CompressThreshold      int                     // 包体达到这个长度才压缩，默认：1024
+	Crypto                 iface.ICrypto           // 包体加密，仅默认的封包方式支持，nil表示不加密
+	Sequence               bool                    // 头部是否带上每个连接递增的序列号
+	SeqDedupWindow         int                     // 序列号去重的窗口大小，0表示不去重
+	SocketRecvBuffer       int                     // 每个连接的SO_RCVBUF，0表示使用系统默认值
+	SocketSendBuffer       int                     // 每个连接的SO_SNDBUF，0表示使用系统默认值
+	EpollWaitTimeout       time.Duration           // epoll_wait/kevent的超时时间，<= 0 表示一直阻塞
+	OnError                iface.ErrorFunc         // 连接出现读写错误，关闭之前回调，在事件循环中同步执行，请勿阻塞
+	LogSampleInterval      time.Duration           // 相同的日志在这个时间内只输出一次，之后输出重复的次数，0表示不合并
+	OnAccept               iface.AcceptFunc        // 新连接加入事件循环之前回调，返回error时关闭连接，在accept循环中同步执行，请勿阻塞
+	ProxyProtocol          bool                    // 每个连接的开头必须是PROXY protocol(v1/v2)头部，对端地址使用头部中的真实地址
+	ProxyHeaderTimeout     time.Duration           // 连接建立后多长时间内必须收到完整的PROXY protocol头部，默认：5秒
+	IDGenerator            func() uint64           // 生成连接ID，需要保证唯一且不为0，默认进程内单调递增
+	BatchDispatch          bool                    // 一次读取中解出的多个消息合并投递，在同一个协程中按顺序处理
+	OverloadPolicy         *OverloadPolicy         // 积压的消息达到高水位时的处理方式，nil表示继续排队
+	OverloadWatermark      int                     // 高水位，等待分发和正在处理的消息数量，<= 0 表示使用EmitChanSize
+	SlowClientBacklog      int                     // 写入积压超过这个字节数，并持续SlowClientDuration时回调OnSlowClient
+	SlowClientDuration     time.Duration           // 写入积压持续的时间
+	OnSlowClient           iface.SlowClientFunc    // 慢连接的回调，在检测的协程中执行，可以在回调中关闭连接
+	FairScheduling         bool                    // 按连接轮流处理消息，由NumWorker个worker处理，同一个连接的消息按顺序处理
+	tlsConfig              atomic.Value            // 新连接使用的*tls.Config，ReloadTLS时替换
 }
 
 type Option = func(opts *Options)
 
+//DefaultMaxBodyLength 未配置包体最大长度时的默认值
+const DefaultMaxBodyLength = 1024 * 1024 * 16
+
+//DefaultSendQueueSize 默认的异步发送队列长度
+const DefaultSendQueueSize = 1024
+
+//DefaultReadBufferSize 默认的读取buffer长度
+const DefaultReadBufferSize = 1024 * 64
+
+//DefaultCompressThreshold 默认的压缩阈值，包体过小时压缩的收益不大
+const DefaultCompressThreshold = 1024
+
+//DefaultEmitChanSize 默认的消息队列长度
+const DefaultEmitChanSize = 128
+
+//DefaultProxyHeaderTimeout 默认等待PROXY protocol头部的时间
+const DefaultProxyHeaderTimeout = time.Second * 5
+
 //parseOption 解析可选项
 func parseOption(opts ...Option) *Options {
-	options := new(Options)
+	options := &Options{
+		MaxBodyLength:  DefaultMaxBodyLength,
+		counters:       &counters{},
+		ctx:            context.Background(),
+		SendQueueSize:  DefaultSendQueueSize,
+		ReadBufferSize: DefaultReadBufferSize,
+		EmitChanSize:   DefaultEmitChanSize,
+		TCPNoDelay:     true,
+	}
 	for _, opt := range opts {
 		opt(options)
 	}
@@ -41,6 +128,106 @@ func parseOption(opts ...Option) *Options {
 	return options
 }
 
+//prepareOption 填充依赖其他配置的默认值，TCP和UDP服务共用
+func prepareOption(options *Options) error {
+
+	// 封包解包的实现层，外部可以自行实现IPacker使用自己的封包解包方式
+	if options.Packer == nil {
+		options.Packer = util.NewDataPacker()
+		options.Packer.SetMaxBodyLength(options.MaxBodyLength)
+	}
+
+	// 包体压缩，只有默认的封包方式会在包头中标记是否压缩
+	if options.Compression != nil {
+		if options.CompressThreshold <= 0 {
+			options.CompressThreshold = DefaultCompressThreshold
+		}
+		if packer, ok := options.Packer.(*util.DataPacker); ok {
+			packer.SetCompression(options.Compression, options.CompressThreshold)
+		}
+	}
+
+	// 序列号
+	if options.Sequence {
+		if packer, ok := options.Packer.(*util.DataPacker); ok {
+			packer.SetSequence(true)
+		}
+	}
+
+	// 包体加密
+	if options.Crypto != nil {
+		if packer, ok := options.Packer.(*util.DataPacker); ok {
+			packer.SetCrypto(options.Crypto)
+		}
+	}
+
+	// 空闲超时，复用心跳检测
+	if options.IdleTimeout > 0 {
+		options.HeartbeatIdleTime = options.IdleTimeout
+		if options.HeartbeatCheckInterval <= 0 {
+			options.HeartbeatCheckInterval = options.IdleTimeout / 2
+		}
+	}
+
+	// ip黑白名单
+	if len(options.AllowedCIDRs) > 0 || len(options.BlockedCIDRs) > 0 {
+		filter, err := util.NewIPFilter(options.AllowedCIDRs, options.BlockedCIDRs)
+		if err != nil {
+			return err
+		}
+		options.ipFilter = filter
+	}
+
+	// 日志，未指定时使用默认的logrus，指定了LogOutput时每个服务使用单独的实例，不会修改全局的util.Logger
+	if options.Logger == nil {
+		logger := util.Logger
+		if options.LogOutput != nil {
+			logger = util.NewLogger()
+			logger.SetOutput(options.LogOutput)
+		}
+		options.Logger = util.NewLogrusLogger(logger)
+	}
+
+	// 合并重复的日志，避免连接风暴、accept持续出错时刷屏
+	options.Logger = util.NewSampledLogger(options.Logger, options.LogSampleInterval)
+
+	// accept队列长度
+	if options.ListenBacklog <= 0 {
+		options.ListenBacklog = util.MaxListenerBacklog()
+	}
+
+	// 公平调度的worker数量
+	if options.NumWorker <= 0 {
+		options.NumWorker = runtime.NumCPU()
+	}
+
+	// 等待PROXY protocol头部的时间
+	if options.ProxyProtocol && options.ProxyHeaderTimeout <= 0 {
+		options.ProxyHeaderTimeout = DefaultProxyHeaderTimeout
+	}
+
+	// 消息队列长度
+	if options.EmitChanSize <= 0 {
+		options.EmitChanSize = DefaultEmitChanSize
+	}
+
+	// 读取buffer复用池
+	if options.ReadBufferSize <= 0 {
+		options.ReadBufferSize = DefaultReadBufferSize
+	}
+
+	// TLS每次最多读取16384字节，buffer不能比这个小，否则剩余的数据会留在TLS层中
+	if options.TlsEnable && options.ReadBufferSize < 16384 {
+		options.ReadBufferSize = 16384
+	}
+	options.readPool = util.NewBufferPool(options.ReadBufferSize)
+
+	// TLS配置，ReloadTLS可以替换
+	initTLSConfig(options)
+
+	return nil
+}
+
 //WithNumEventLoop event-loop数量配置
 func WithNumEventLoop(numEventLoop int) Option {
 	return func(opts *Options) {
@@ -48,6 +235,13 @@ func WithNumEventLoop(numEventLoop int) Option {
 	}
 }
 
+//WithTCPNoDelay 是否设置TCP_NODELAY，默认开启，关闭后使用Nagle算法合并小包，吞吐更高但延迟会增加
+func WithTCPNoDelay(noDelay bool) Option {
+	return func(opts *Options) {
+		opts.TCPNoDelay = noDelay
+	}
+}
+
 //WithTCPKeepAlive 设置时间 TCP keepalive
 func WithTCPKeepAlive(duration time.Duration) Option {
 	return func(opts *Options) {
@@ -76,7 +270,7 @@ func WithHooks(hooks iface.IHooks) Option {
 	}
 }
 
-//WithMaxBodyLength 配置包体部分最大长度
+//WithMaxBodyLength 配置包体部分最大长度，超过后连接会被关闭，0表示不限制
 func WithMaxBodyLength(length uint32) Option {
 	return func(opts *Options) {
 		opts.MaxBodyLength = length
@@ -118,3 +312,319 @@ func WithTLSConfig(config *tls.Config) Option {
 		opts.TlsEnable = true
 	}
 }
+
+//WithOnConnect 连接建立后的回调
+func WithOnConnect(callback func(conn iface.IConnect)) Option {
+	return func(opts *Options) {
+		opts.OnConnect = callback
+	}
+}
+
+//WithOnClose 连接关闭后的回调
+func WithOnClose(callback func(conn iface.IConnect)) Option {
+	return func(opts *Options) {
+		opts.OnClose = callback
+	}
+}
+
+//WithOnPanic 路由处理发生panic时的回调
+func WithOnPanic(callback iface.PanicHandler) Option {
+	return func(opts *Options) {
+		opts.OnPanic = callback
+	}
+}
+
+//WithMaxConnections 最大连接数限制
+func WithMaxConnections(max int) Option {
+	return func(opts *Options) {
+		opts.MaxConnections = max
+	}
+}
+
+//WithRejectPayload 超过最大连接数时，关闭连接前发送的数据，需要是已经封包好的数据
+func WithRejectPayload(payload []byte) Option {
+	return func(opts *Options) {
+		opts.RejectPayload = payload
+	}
+}
+
+//WithIdleTimeout 空闲超时，连接超过这个时间没有收到任何数据会被关闭
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.IdleTimeout = timeout
+	}
+}
+
+//WithWriteTimeout 写入超时时间，对端长时间不接收数据时，Send会返回util.WriteTimeout
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.WriteTimeout = timeout
+	}
+}
+
+//WithMaxConnectionsPerIP 同一个ip的最大连接数限制
+func WithMaxConnectionsPerIP(max int) Option {
+	return func(opts *Options) {
+		opts.MaxConnectionsPerIP = max
+	}
+}
+
+//WithAllowedCIDRs ip白名单，如：192.168.0.0/16，也可以是单个ip
+func WithAllowedCIDRs(cidrs ...string) Option {
+	return func(opts *Options) {
+		opts.AllowedCIDRs = cidrs
+	}
+}
+
+//WithBlockedCIDRs ip黑名单，如：10.0.0.0/8，也可以是单个ip
+func WithBlockedCIDRs(cidrs ...string) Option {
+	return func(opts *Options) {
+		opts.BlockedCIDRs = cidrs
+	}
+}
+
+//WithHeartbeat 应用层心跳，定时发送ping，超时未收到pong的连接会被关闭
+func WithHeartbeat(heartbeat *Heartbeat) Option {
+	return func(opts *Options) {
+		opts.Heartbeat = heartbeat
+	}
+}
+
+//WithDualStack 开启双栈，监听0.0.0.0或::时同时接收IPv4和IPv6连接
+func WithDualStack(dualStack bool) Option {
+	return func(opts *Options) {
+		opts.DualStack = dualStack
+	}
+}
+
+//WithWebsocketUpgrade 路由模式下同时支持websocket客户端，第一次收到的数据为websocket握手请求时升级为websocket
+//websocket帧中的数据需要是封包后的数据，和TCP客户端使用同一套路由
+func WithWebsocketUpgrade(enable bool) Option {
+	return func(opts *Options) {
+		opts.WebsocketUpgrade = enable
+	}
+}
+
+//WithSendQueue 异步发送队列配置，size为队列长度，policy为队列已满时的处理方式
+func WithSendQueue(size int, policy common.SendQueuePolicy) Option {
+	return func(opts *Options) {
+		opts.SendQueueSize = size
+		opts.SendQueuePolicy = policy
+	}
+}
+
+//WithLoopBalancer 新连接分配到事件循环的策略，内置eventloop.RoundRobin、eventloop.LeastConnections、eventloop.SourceHash
+func WithLoopBalancer(balancer iface.ILoopBalancer) Option {
+	return func(opts *Options) {
+		opts.LoopBalancer = balancer
+	}
+}
+
+//WithReadBufferSize 读取包体时每次最多读取的字节数，buffer会被复用
+func WithReadBufferSize(size int) Option {
+	return func(opts *Options) {
+		opts.ReadBufferSize = size
+	}
+}
+
+//WithEmitChan 事件循环投递消息的队列配置，size为队列长度，policy为队列已满时的处理方式
+func WithEmitChan(size int, policy common.EmitPolicy) Option {
+	return func(opts *Options) {
+		opts.EmitChanSize = size
+		opts.EmitPolicy = policy
+	}
+}
+
+//WithLogger 自定义日志，所有内部日志都会通过这个实例输出
+func WithLogger(logger iface.ILogger) Option {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
+//WithHandlerTimeout 单条消息的处理超时时间，超时后会取消request.Context()并记录日志
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.HandlerTimeout = timeout
+	}
+}
+
+//WithReusePort 设置SO_REUSEPORT，多个进程可以监听同一个端口，由内核将新连接分配给各个进程
+func WithReusePort(reusePort bool) Option {
+	return func(opts *Options) {
+		opts.ReusePort = reusePort
+	}
+}
+
+//WithListenBacklog 设置listen的backlog，连接突增时backlog过小会导致SYN被丢弃，默认为系统的最大值
+func WithListenBacklog(backlog int) Option {
+	return func(opts *Options) {
+		opts.ListenBacklog = backlog
+	}
+}
+
+//WithReadRateLimit 单个连接的读取限速，rate为每秒最多读取的字节数，burst为允许突发读取的字节数
+//超过速率后会暂停读取，等待一段时间后继续读取，不会断开连接
+func WithReadRateLimit(rate, burst int) Option {
+	return func(opts *Options) {
+		opts.ReadRateLimit = rate
+		opts.ReadRateBurst = burst
+	}
+}
+
+//WithNotFoundHandler 未匹配到路由时的处理方法
+func WithNotFoundHandler(handler iface.IRouter) Option {
+	return func(opts *Options) {
+		opts.NotFoundHandler = handler
+	}
+}
+
+//WithEpollEdgeTriggered 使用边缘触发，每次可读事件都会一直读取到EAGAIN，可以减少epoll_wait的次数
+//水平触发时每次事件只读取一个包，未读取完的数据会再次通知
+func WithEpollEdgeTriggered(edgeTriggered bool) Option {
+	return func(opts *Options) {
+		opts.EpollEdgeTriggered = edgeTriggered
+	}
+}
+
+//WithDialTimeout 客户端的连接超时时间，超时后Dial、Connect返回util.DialTimeout
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.DialTimeout = timeout
+	}
+}
+
+//WithReconnect 客户端断开后自动重连
+func WithReconnect(reconnect *Reconnect) Option {
+	return func(opts *Options) {
+		opts.Reconnect = reconnect
+	}
+}
+
+//WithCompression 包体长度达到threshold时压缩，接收时自动解压，路由中拿到的始终是原始数据
+//通过长度字段的最高位标记是否压缩，收发双方都需要设置，仅默认的封包方式支持
+func WithCompression(compressor iface.ICompressor, threshold int) Option {
+	return func(opts *Options) {
+		opts.Compression = compressor
+		opts.CompressThreshold = threshold
+	}
+}
+
+//WithCrypto 加密包体，适合不需要TLS的内网环境，收发双方都需要设置，仅默认的封包方式支持
+func WithCrypto(crypto iface.ICrypto) Option {
+	return func(opts *Options) {
+		opts.Crypto = crypto
+	}
+}
+
+//WithSequence 头部带上每个连接递增的序列号(8字节)，dedupWindow > 0 时丢弃最近dedupWindow个序列号内重复的消息
+//收发双方都需要设置，自定义的封包方式需要实现iface.ISeqPacker
+func WithSequence(dedupWindow int) Option {
+	return func(opts *Options) {
+		opts.Sequence = true
+		opts.SeqDedupWindow = dedupWindow
+	}
+}
+
+//WithSocketBuffer 设置每个连接内核的收发缓冲区大小，单位为字节，0表示使用系统默认值
+//高延迟、高带宽的链路上，单个连接的吞吐量约为 缓冲区大小 / RTT，默认值过小时无法跑满带宽
+func WithSocketBuffer(recv, send int) Option {
+	return func(opts *Options) {
+		opts.SocketRecvBuffer = recv
+		opts.SocketSendBuffer = send
+	}
+}
+
+//WithEpollWaitTimeout 事件循环最多阻塞多长时间，超时后会检查是否已停止，<= 0 表示一直阻塞直到有事件
+func WithEpollWaitTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.EpollWaitTimeout = timeout
+	}
+}
+
+//WithOnError 连接出现读写错误(如：ECONNRESET、EPIPE、协议错误)时的回调，之后会关闭连接，对端正常关闭时不会回调
+func WithOnError(callback func(conn iface.IConnect, err error)) Option {
+	return func(opts *Options) {
+		opts.OnError = callback
+	}
+}
+
+//WithLogSampleInterval 相同级别、相同格式的日志在interval内只输出第一条，之后合并为一条"repeated N times"
+func WithLogSampleInterval(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.LogSampleInterval = interval
+	}
+}
+
+//WithOnAccept 新连接加入事件循环之前回调，可以检查对端地址、读取原始的socket，返回error时直接关闭连接，不会执行OnConnect、OnClose
+//在ip黑白名单、连接数限制之后执行，此时fd还是阻塞模式
+func WithOnAccept(callback func(fd int, addr net.Addr) error) Option {
+	return func(opts *Options) {
+		opts.OnAccept = callback
+	}
+}
+
+//WithProxyProtocol 服务部署在HAProxy、AWS NLB等四层负载均衡之后时开启，解析连接开头的PROXY protocol头部(v1/v2)，
+//GetAddress返回头部中客户端的真实地址，ip黑白名单、同一个ip的连接数限制也使用真实地址，未发送头部的连接会被关闭
+func WithProxyProtocol(enable bool) Option {
+	return func(opts *Options) {
+		opts.ProxyProtocol = enable
+	}
+}
+
+//WithProxyHeaderTimeout 连接建立后超过这个时间仍未收到完整的PROXY protocol头部时关闭连接，默认：5秒
+func WithProxyHeaderTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.ProxyHeaderTimeout = timeout
+	}
+}
+
+//WithIDGenerator 自定义连接ID的生成方式，如在ID中带上实例编号，多个实例之间的连接ID也不会重复
+//每个连接只会调用一次，可能在多个协程中同时调用，需要保证并发安全，生成的ID必须唯一且不为0
+func WithIDGenerator(generator func() uint64) Option {
+	return func(opts *Options) {
+		opts.IDGenerator = generator
+	}
+}
+
+//WithBatchDispatch 一次读取中解出的多个完整的包合并为一次投递，减少消息队列的开销，适合客户端大量pipeline发送的场景
+//同一批的消息在同一个协程中按顺序处理，不再是每个消息一个协程，水平触发时每次可读事件也会一直读取
+func WithBatchDispatch(enable bool) Option {
+	return func(opts *Options) {
+		opts.BatchDispatch = enable
+	}
+}
+
+//WithOverloadPolicy 等待分发和正在处理的消息数量达到highWatermark时，新消息按policy拒绝或丢弃，给客户端明确的反馈
+//如：server.WithOverloadPolicy(server.OverloadRejectFrame(503, []byte("server busy")), 1024)，highWatermark <= 0 时使用EmitChanSize
+func WithOverloadPolicy(policy *OverloadPolicy, highWatermark int) Option {
+	return func(opts *Options) {
+		opts.OverloadPolicy = policy
+		opts.OverloadWatermark = highWatermark
+	}
+}
+
+//WithOnSlowClient 连接的写入积压(WriteBacklog)超过backlog字节并持续duration时回调，可以记录日志或关闭一直接收不过来的连接，避免占用过多内存
+//每次积压只回调一次，积压降到backlog以下后再次超过时才会重新计时
+func WithOnSlowClient(backlog int, duration time.Duration, callback iface.SlowClientFunc) Option {
+	return func(opts *Options) {
+		opts.SlowClientBacklog = backlog
+		opts.SlowClientDuration = duration
+		opts.OnSlowClient = callback
+	}
+}
+
+//WithFairScheduling 按连接轮流处理消息，一个连接发送再多的消息也只会占用一个worker，不会让其他连接一直排队，适合多租户的服务
+//同一个连接的消息按顺序逐个处理，同时处理的消息最多为NumWorker个，路由中阻塞会占用worker，单个连接的吞吐量也会降低
+func WithFairScheduling(enable bool) Option {
+	return func(opts *Options) {
+		opts.FairScheduling = enable
+	}
+}
+
+//WithNumWorker 开启FairScheduling时处理消息的worker数量，默认CPU核心数
+func WithNumWorker(num int) Option {
+	return func(opts *Options) {
+		opts.NumWorker = num
+	}
+}
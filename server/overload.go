@@ -0,0 +1,92 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//OverloadPolicy 等待分发和正在处理的消息达到高水位时的处理方式，给客户端明确的反馈，避免延迟无限增长
+type OverloadPolicy struct {
+	Mode  common.OverloadMode // 处理方式
+	MsgID uint32              // OverloadReject时回复的msgID
+	Data  []byte              // OverloadReject时回复的数据，如：{"code":503,"msg":"server busy"}
+	Close bool                // 拒绝、丢弃之后是否关闭连接，CloseReason为common.CloseRejected
+}
+
+//OverloadQueue 继续排队，和未配置时一样
+func OverloadQueue() *OverloadPolicy {
+	return &OverloadPolicy{Mode: common.OverloadQueue}
+}
+
+//OverloadRejectFrame 回复msgID、data组成的数据包，不分发到路由
+func OverloadRejectFrame(msgID uint32, data []byte) *OverloadPolicy {
+	return &OverloadPolicy{Mode: common.OverloadReject, MsgID: msgID, Data: data}
+}
+
+//OverloadDrop 直接丢弃，不分发到路由
+func OverloadDrop() *OverloadPolicy {
+	return &OverloadPolicy{Mode: common.OverloadDrop}
+}
+
+//overloadCloseTimeout Close为true时，等待拒绝的数据发送完毕的最长时间
+const overloadCloseTimeout = time.Second
+
+//apply 拒绝或丢弃一条消息，在doMessage的协程中执行，发送、关闭都不能阻塞，否则一个慢连接会拖慢所有连接的分发
+func (p *OverloadPolicy) apply(ctx iface.IContext, logger iface.ILogger) {
+	connect := ctx.GetConnect()
+	if p.Mode == common.OverloadReject {
+		// 不支持异步发送时(如：websocket)在新的协程中发送
+		if err := connect.AsyncSend(p.MsgID, p.Data); err == util.ApplicationNotRouterMode {
+			go func() {
+				_, _ = connect.Send(p.MsgID, p.Data)
+			}()
+		}
+	} else {
+		logger.Warnf("server overloaded, drop requestID[%d] msgID[%d] of connID[%d]", ctx.GetRequest().ID(), ctx.GetMessage().ID(), connect.GetID())
+	}
+
+	// 发送完拒绝的数据后再关闭，OnClose也不会在doMessage的协程中执行
+	if p.Close {
+		if setter, ok := connect.(closeReasonSetter); ok {
+			setter.SetCloseReason(common.CloseRejected)
+		}
+		go func() {
+			_ = connect.CloseGracefully(overloadCloseTimeout)
+		}()
+	}
+}
+
+//shed 积压的消息达到高水位时按OverloadPolicy处理，返回true表示已经处理，不再分发到路由
+func (s *Server) shed(context iface.IContext) bool {
+	policy := s.options.OverloadPolicy
+	if policy == nil || policy.Mode == common.OverloadQueue {
+		return false
+	}
+
+	// 未配置高水位时使用消息队列的长度
+	watermark := s.options.OverloadWatermark
+	if watermark <= 0 {
+		watermark = cap(s.emitCh)
+	}
+	if len(s.emitCh)+int(atomic.LoadInt64(&s.inflight)) < watermark {
+		return false
+	}
+
+	contexts := []iface.IContext{context}
+	if batch, ok := context.(*util.Batch); ok {
+		contexts = batch.Contexts
+	}
+	for _, ctx := range contexts {
+
+		// 心跳的pong不受影响，否则过载时连接会因为心跳超时被关闭
+		if s.options.Heartbeat.isPong(ctx) {
+			continue
+		}
+		policy.apply(ctx, s.options.Logger)
+	}
+	return true
+}
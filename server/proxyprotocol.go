@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
+)
+
+//proxyV1Prefix PROXY protocol v1 文本格式的开头
+var proxyV1Prefix = []byte("PROXY ")
+
+//proxyV2Signature PROXY protocol v2 二进制格式的12字节签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLength   = 107 // v1头部的最大长度，包括结尾的\r\n
+	proxyV2HeaderSize  = 16  // v2固定部分的长度：签名12字节 + 版本命令1字节 + 协议族1字节 + 地址长度2字节
+	proxyReadChunkSize = 256 // 每次读取头部的字节数
+)
+
+//readProxyHeader 读取并解析连接开头的PROXY protocol头部，头部还不完整时返回unix.EAGAIN
+//多读取的数据保存在proxyRest中，由Read优先返回，不会影响之后的TLS握手和解包
+func (c *BaseConnect) readProxyHeader() error {
+	for {
+		// 直接读取到proxyBuf的剩余空间中，多次读取复用同一个buffer
+		if cap(c.proxyBuf)-len(c.proxyBuf) < proxyReadChunkSize {
+			buf := make([]byte, len(c.proxyBuf), len(c.proxyBuf)+proxyReadChunkSize)
+			copy(buf, c.proxyBuf)
+			c.proxyBuf = buf
+		}
+		bs := c.proxyBuf[len(c.proxyBuf) : len(c.proxyBuf)+proxyReadChunkSize]
+		n, err := unix.Read(c.fd, bs)
+		if n > 0 {
+			c.SetLastMessageTime(time.Now())
+			c.addRead(n)
+			c.proxyBuf = c.proxyBuf[:len(c.proxyBuf)+n]
+		}
+
+		if n == 0 && err == nil {
+			return io.EOF
+		}
+
+		address, length, perr := parseProxyHeader(c.proxyBuf)
+		if perr != nil {
+			return perr
+		}
+
+		// 头部还不完整，等待下一次可读
+		if length == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		c.proxyPending = false
+		c.proxyRest = c.proxyBuf[length:]
+		c.proxyBuf = nil
+
+		// LOCAL、UNKNOWN时使用原始的对端地址，如负载均衡的健康检查
+		if address == nil {
+			return nil
+		}
+		return c.setProxyAddress(address)
+	}
+}
+
+//watchProxyHeader 超过ProxyHeaderTimeout仍未收到完整的头部时关闭连接，避免不发送头部的客户端一直占用连接
+//在事件循环的协程中检查，和readProxyHeader不会同时访问proxyPending
+func (c *BaseConnect) watchProxyHeader(connect iface.IConnect) {
+	time.AfterFunc(c.options.ProxyHeaderTimeout, func() {
+		poller := c.GetPoller()
+		if poller == nil {
+			return
+		}
+		_ = poller.Submit(func() {
+			if !c.proxyPending || atomic.LoadInt32(&c.closed) == 1 {
+				return
+			}
+
+			c.SetCloseError(util.ProxyHeaderTimeout)
+			if c.options.OnError != nil {
+				c.options.OnError(connect, util.ProxyHeaderTimeout)
+			}
+			_ = closeWith(connect, common.CloseRejected)
+		})
+	})
+}
+
+//setProxyAddress 使用PROXY protocol中客户端的真实地址，并按真实地址检查ip黑白名单、同一个ip的连接数
+func (c *BaseConnect) setProxyAddress(address net.Addr) error {
+	connectMgr := c.GetConnectMgr()
+	if !allowAddress(c.options, connectMgr, address) {
+		return util.ProxyAddressRejected
+	}
+
+	if mgr, ok := connectMgr.(*ConnectManager); ok {
+		mgr.readdress(c, address)
+		return nil
+	}
+	c.Address = address
+	return nil
+}
+
+//parseProxyHeader 解析PROXY protocol头部，返回客户端地址和头部的长度
+//长度为0表示数据还不完整，地址为nil表示LOCAL、UNKNOWN等不携带地址的头部
+func parseProxyHeader(bs []byte) (net.Addr, int, error) {
+	if hasPrefix(bs, proxyV2Signature) {
+		return parseProxyV2(bs)
+	}
+	if hasPrefix(bs, proxyV1Prefix) {
+		return parseProxyV1(bs)
+	}
+	return nil, 0, util.ProxyHeaderInvalid
+}
+
+//hasPrefix bs是否以prefix开头，bs比prefix短时只比较已有的部分
+func hasPrefix(bs, prefix []byte) bool {
+	if len(bs) < len(prefix) {
+		return bytes.Equal(bs, prefix[:len(bs)])
+	}
+	return bytes.Equal(bs[:len(prefix)], prefix)
+}
+
+//parseProxyV1 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func parseProxyV1(bs []byte) (net.Addr, int, error) {
+	end := bytes.Index(bs, []byte("\r\n"))
+	if end < 0 {
+		if len(bs) >= proxyV1MaxLength {
+			return nil, 0, util.ProxyHeaderInvalid
+		}
+		return nil, 0, nil
+	}
+	if end+2 > proxyV1MaxLength {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+
+	fields := strings.Split(string(bs[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || net.ParseIP(fields[3]) == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+	if _, err := strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, end + 2, nil
+}
+
+//parseProxyV2 二进制格式，地址之后的TLV会被忽略
+func parseProxyV2(bs []byte) (net.Addr, int, error) {
+	if len(bs) < proxyV2HeaderSize {
+		return nil, 0, nil
+	}
+
+	// 高4位是版本，必须是2，低4位是命令，0:LOCAL 1:PROXY
+	if bs[12]>>4 != 2 {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+	command := bs[12] & 0x0F
+	if command > 1 {
+		return nil, 0, util.ProxyHeaderInvalid
+	}
+
+	length := proxyV2HeaderSize + int(binary.BigEndian.Uint16(bs[14:16]))
+	if len(bs) < length {
+		return nil, 0, nil
+	}
+	if command == 0 {
+		return nil, length, nil
+	}
+
+	// 高4位是地址族，1:IPv4 2:IPv6，其他(UNSPEC、UNIX)不携带ip地址
+	payload := bs[proxyV2HeaderSize:length]
+	switch bs[13] >> 4 {
+	case 1:
+		if len(payload) < 12 {
+			return nil, 0, util.ProxyHeaderInvalid
+		}
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, payload[:4])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[8:10]))}, length, nil
+	case 2:
+		if len(payload) < 36 {
+			return nil, 0, util.ProxyHeaderInvalid
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, payload[:16])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[32:34]))}, length, nil
+	}
+	return nil, length, nil
+}
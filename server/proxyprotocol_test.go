@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//addressRouter 记录连接的对端地址，并原样返回收到的数据
+type addressRouter struct {
+	address chan string
+}
+
+func (r *addressRouter) Do(request iface.IRequest) {
+	r.address <- request.GetConnect().GetAddress().String()
+	_, _ = request.GetConnect().Send(request.GetMessage().ID(), request.GetMessage().Bytes())
+}
+
+func TestProxyHeaderTimeout(t *testing.T) {
+	errCh := make(chan error, 1)
+	closed := make(chan common.CloseReason, 1)
+	s := startServer(t,
+		WithProxyProtocol(true),
+		WithProxyHeaderTimeout(100*time.Millisecond),
+		WithOnError(func(connect iface.IConnect, err error) {
+			errCh <- err
+		}),
+		WithOnClose(func(connect iface.IConnect) {
+			closed <- connect.CloseReason()
+		}),
+	)
+
+	// 建立连接后不发送任何数据
+	start := time.Now()
+	dial(t, s)
+
+	select {
+	case reason := <-closed:
+		if reason != common.CloseRejected {
+			t.Fatalf("close reason %v, want %v", reason, common.CloseRejected)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("closed after %v, before the header timeout", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection without a PROXY header was not closed")
+	}
+	if err := <-errCh; err != util.ProxyHeaderTimeout {
+		t.Fatalf("OnError got %v, want ProxyHeaderTimeout", err)
+	}
+}
+
+func TestProxyHeaderSplit(t *testing.T) {
+	router := &addressRouter{address: make(chan string, 2)}
+	s := startServer(t,
+		WithProxyProtocol(true),
+		WithProxyHeaderTimeout(time.Second),
+	)
+	s.AddRouter(1, router)
+
+	// 头部分多次到达，之后紧跟着数据包
+	conn := dial(t, s)
+	header := []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n")
+	for i := range header {
+		if _, err := conn.Write(header[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assertEcho(t, conn)
+
+	if got := <-router.address; got != "192.168.0.1:56324" {
+		t.Fatalf("address %s, want the address from the PROXY header", got)
+	}
+
+	// 头部已完整，超时后连接仍然可用
+	time.Sleep(1200 * time.Millisecond)
+	assertEcho(t, conn)
+}
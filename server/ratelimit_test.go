@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//largeReplyRouter 回复比内核缓冲区更大的数据，写入会进入等待可写的状态
+type largeReplyRouter struct{}
+
+func (*largeReplyRouter) Do(request iface.IRequest) {
+	_, _ = request.GetConnect().Send(1, bytes.Repeat([]byte("x"), 1<<18))
+}
+
+//TestReadRateLimitWhileWriting 限速恢复读取的定时器和等待可写的写入同时发生，需要go test -race
+func TestReadRateLimitWhileWriting(t *testing.T) {
+	const frames = 20
+
+	s := startServer(t, WithReadRateLimit(16*1024, 2*1024))
+	s.AddRouter(1, new(largeReplyRouter))
+	conn := dial(t, s)
+
+	done := make(chan error, 1)
+	go func() {
+		frame := packFrame(t, 1, make([]byte, 1024))
+		for i := 0; i < frames; i++ {
+			if _, err := conn.Write(frame); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for i := 0; i < frames; i++ {
+		message, err := readFrame(conn, 5*time.Second)
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if message.Len() != 1<<18 {
+			t.Fatalf("reply %d has %d bytes", i, message.Len())
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
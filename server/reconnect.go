@@ -0,0 +1,85 @@
+package server
+
+import (
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//Reconnect 客户端断开后自动重连，调用Client.Close后不再重连
+type Reconnect struct {
+	Enabled     bool                            // 是否开启
+	MaxRetries  int                             // 最多重试的次数，0表示一直重试
+	Backoff     func(attempt int) time.Duration // 第attempt次重试前等待的时间，attempt从1开始，默认从100ms开始翻倍，最长30秒
+	OnReconnect func(conn iface.IConnect)       // 重连成功后执行，可以重新认证、订阅，在OnConnect之后执行
+}
+
+//enabled 是否开启了自动重连
+func (r *Reconnect) enabled() bool {
+	return r != nil && r.Enabled
+}
+
+//backoff 第attempt次重试前等待的时间
+func (r *Reconnect) backoff(attempt int) time.Duration {
+	if r.Backoff != nil {
+		return r.Backoff(attempt)
+	}
+
+	delay := 100 * time.Millisecond
+	for i := 1; i < attempt && delay < 30*time.Second; i++ {
+		delay *= 2
+	}
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+//watch 等待连接断开后重连，重连成功后由新的连接继续等待，每个连接只会有一个goroutine，Close后退出
+func (c *Client) watch(connect iface.IConnect) {
+
+	<-connect.Context().Done()
+
+	// 已经调用Connect换成了新的连接
+	c.lock.Lock()
+	if c.connect != connect {
+		c.lock.Unlock()
+		return
+	}
+	c.connect = nil
+	c.lock.Unlock()
+
+	reconnect := c.options.Reconnect
+	for attempt := 1; reconnect.MaxRetries <= 0 || attempt <= reconnect.MaxRetries; attempt++ {
+
+		timer := time.NewTimer(reconnect.backoff(attempt))
+		select {
+		case <-c.options.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// 等待期间已手动连接
+		if c.Conn() != nil {
+			return
+		}
+
+		if err := c.Connect(); err != nil {
+			if err == util.ClientClosed {
+				return
+			}
+			c.options.Logger.Warnf("reconnect %s attempt %d failed: %v", c.address, attempt, err)
+			continue
+		}
+
+		c.options.Logger.Infof("reconnect %s succeeded after %d attempts", c.address, attempt)
+		if reconnect.OnReconnect != nil {
+			reconnect.OnReconnect(c.Conn())
+		}
+		return
+	}
+
+	c.options.Logger.Errorf("reconnect %s failed after %d attempts, give up", c.address, reconnect.MaxRetries)
+}
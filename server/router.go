@@ -6,19 +6,28 @@ import (
 	"github.com/ikilobyte/netman/util"
 	"golang.org/x/sys/unix"
 	"io"
+	"net/url"
+	"sync"
+	"syscall"
 )
 
 type routerProtocol struct {
 	*BaseConnect
-	readBuffer       *bytes.Buffer // 未读取完整的一个数据包
-	packDataLength   uint32        // 数据包体长度，如果这个值 == 0，那就是从头开始读取，没有未读取完整的数据
+	bodyChunks       []*[]byte // 未读取完整的包体，保存在复用池的buffer中，读取完整后复制出来并放回
+	bodyRead         int       // 已读取的包体长度
+	packDataLength   uint32    // 数据包体长度，如果这个值 == 0，那就是从头开始读取，没有未读取完整的数据
 	temporaryMessage iface.IMessage
+	detected         bool               // 是否已探测过协议，开启WebsocketUpgrade时使用
+	ws               *websocketProtocol // 升级为websocket后，由这里解析websocket帧
+	headBuffer       []byte             // 未读取完整的包头
+	readPacker       iface.IPacker      // 正在读取的包头使用的packer，读取完整之前调用SetPacker不会切换
+	pending          [][]byte           // SendNoFlush缓冲的数据包，Flush时一次性发送
+	pendingLock      sync.Mutex         //
 }
 
 //newRouterProtocol .
 func newRouterProtocol(baseConnect *BaseConnect) iface.IConnect {
 	connect := &routerProtocol{
-		readBuffer:       bytes.NewBuffer([]byte{}),
 		packDataLength:   0,
 		temporaryMessage: nil,
 		BaseConnect:      baseConnect,
@@ -30,25 +39,24 @@ func newRouterProtocol(baseConnect *BaseConnect) iface.IConnect {
 //Close 关闭连接
 func (c *routerProtocol) Close() error {
 
-	// 移除事件监听
-	_ = c.GetPoller().Remove(c.fd)
+	// 已升级为websocket，发送close帧后关闭
+	if c.ws != nil {
+		return c.ws.Close()
+	}
+
+	// 路由、事件循环、ClearAll可能同时关闭，只有第一次会执行，重复关闭fd可能会关闭掉被复用的新连接
+	if !c.markClosed() {
+		return nil
+	}
 
-	// 从管理类中移除
-	c.GetConnectMgr().Remove(c)
+	// 移除事件监听，从管理类中移除
+	c.detach(c)
 
 	// 关闭连接
 	err := unix.Close(c.fd)
 
-	// 重置为0
-	c.packDataLength = 0
-
-	// 重置
-	c.readBuffer = nil
-
-	// 关闭成功才执行
-	if c.hooks != nil && err == nil {
-		c.hooks.OnClose(c)
-	}
+	// 事件循环可能还在读取，不能重置bodyChunks等状态
+	c.runCloseHooks(c)
 
 	return err
 }
@@ -56,10 +64,25 @@ func (c *routerProtocol) Close() error {
 //DecodePacket 解码出一个数据包
 func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 
+	// 探测是否为websocket客户端
+	if c.options.WebsocketUpgrade && !c.detected {
+		if err := c.detect(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ws != nil {
+		return c.decodeWebsocket()
+	}
+
 	if c.packDataLength <= 0 {
 
-		// 读取包头
-		headBytes := make([]byte, c.packer.GetHeaderLength())
+		// 从头开始读取一个包时才切换packer，包头也可能分多次可读事件才能读取完整
+		if len(c.headBuffer) == 0 {
+			c.readPacker = c.GetPacker()
+		}
+		headerLength := int(c.readPacker.GetHeaderLength())
+		headBytes := make([]byte, headerLength-len(c.headBuffer))
 		n, err := c.readData(headBytes)
 
 		// 连接断开
@@ -67,6 +90,10 @@ func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 			return nil, io.EOF
 		}
 
+		if n > 0 {
+			c.headBuffer = append(c.headBuffer, headBytes[:n]...)
+		}
+
 		// 有错误，可能是 unix.EAGAIN 等错误
 		if err != nil {
 			// fd有异常
@@ -76,13 +103,15 @@ func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 			return nil, err
 		}
 
-		// 包头数据读取有误
-		if n != len(headBytes) {
-			return nil, util.HeadBytesLengthFail
+		// 包头还不完整，等待下一次可读
+		if len(c.headBuffer) < headerLength {
+			return nil, nil
 		}
+		headBytes = c.headBuffer
+		c.headBuffer = nil
 
 		// 解包
-		message, err := c.packer.UnPack(headBytes)
+		message, err := c.readPacker.UnPack(headBytes)
 		if err != nil {
 			return nil, err
 		}
@@ -92,20 +121,33 @@ func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 			return message, nil
 		}
 
-		// 设置长度数据
+		// 设置长度数据，包体不按包头中的长度一次性分配，否则只发送包头的连接就能占用MaxBodyLength的内存
 		c.packDataLength = uint32(message.Len())
 		c.temporaryMessage = message
 	}
 
 	// 本次读取的最大长度
 	// 如果是TLS，那么每次最大读取16384字节即可 https://datatracker.ietf.org/doc/html/rfc8449
-	size := c.packDataLength - uint32(c.readBuffer.Len())
+	size := c.packDataLength - uint32(c.bodyRead)
 	if c.handshakeCompleted && size > 16384 {
 		size = 16384
 	}
-	readBytes := make([]byte, size)
+
+	// 读取到复用池的buffer中，随着数据到达再获取新的buffer
+	chunkSize := c.options.readPool.Size()
+	if len(c.bodyChunks) <= c.bodyRead/chunkSize {
+		c.bodyChunks = append(c.bodyChunks, c.options.readPool.Get())
+	}
+	offset := c.bodyRead % chunkSize
+	if int(size) > chunkSize-offset {
+		size = uint32(chunkSize - offset)
+	}
+	readBytes := (*c.bodyChunks[len(c.bodyChunks)-1])[offset : offset+int(size)]
 
 	n, err := c.readData(readBytes)
+	if n > 0 {
+		c.bodyRead += n
+	}
 
 	// 连接断开
 	if n == 0 && err == io.EOF {
@@ -119,28 +161,18 @@ func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 		if err == unix.EBADF || err == unix.EPIPE {
 			return nil, io.EOF
 		}
-
-		// 保存到这里
-		if n > 0 {
-			c.readBuffer.Write(readBytes[:n])
-		}
 		return nil, err
 	}
 
-	// 将读取到的数据保存到这里
-	c.readBuffer.Write(readBytes[:n])
-
 	if c.GetHandshakeCompleted() {
 		c.tlsRawSize -= n
 	}
 
 	// 数据包完整
-	if c.readBuffer.Len() == int(c.packDataLength) {
-
-		c.temporaryMessage.SetData(c.readBuffer.Bytes())
+	if c.bodyRead == int(c.packDataLength) {
 
-		// 重置，每个数据包都是一个互不影响的slice
-		c.readBuffer = bytes.NewBuffer([]byte{})
+		// 复制为一个完整的slice，每个数据包都是互不影响的，buffer放回复用池
+		c.temporaryMessage.SetData(c.joinBody())
 
 		// 重置包体总长度
 		c.packDataLength = 0
@@ -148,10 +180,20 @@ func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 		// 重置
 		c.tlsRawSize = 0
 
+		// 已加密、压缩的包体需要解密、解压
+		if err := decodeBody(c.temporaryMessage); err != nil {
+			return nil, err
+		}
+
+		// 重复的序列号直接丢弃
+		if c.isReplay(c.temporaryMessage) {
+			return nil, nil
+		}
+
 		return c.temporaryMessage, nil
 	} else {
 
-		remain := c.packDataLength - uint32(c.readBuffer.Len())
+		remain := c.packDataLength - uint32(c.bodyRead)
 
 		// 已完成了TLS握手
 		if c.GetHandshakeCompleted() && c.tlsRawSize >= int(remain) {
@@ -162,16 +204,56 @@ func (c *routerProtocol) DecodePacket() (iface.IMessage, error) {
 	return nil, nil
 }
 
+//joinBody 把包体从复用池的buffer中复制出来，并重置读取状态
+func (c *routerProtocol) joinBody() []byte {
+	body := make([]byte, 0, c.bodyRead)
+	for _, chunk := range c.bodyChunks {
+		remain := c.bodyRead - len(body)
+		if remain > len(*chunk) {
+			remain = len(*chunk)
+		}
+		body = append(body, (*chunk)[:remain]...)
+		c.options.readPool.Put(chunk)
+	}
+	c.bodyChunks = c.bodyChunks[:0]
+	c.bodyRead = 0
+	return body
+}
+
 //Send 写数据
 func (c *routerProtocol) Send(msgID uint32, bytes []byte) (int, error) {
+	if c.isDraining() {
+		return 0, util.ConnectClosing
+	}
+	return c.send(msgID, bytes)
+}
+
+//send 封包后发送
+func (c *routerProtocol) send(msgID uint32, bytes []byte) (int, error) {
 
 	// 1、封包
-	dataPack, err := c.packer.Pack(msgID, bytes)
+	dataPack, err := c.pack(msgID, bytes)
 	if err != nil {
 		return 0, err
 	}
 
 	// 2、发送
+	return c.writePacket(dataPack)
+}
+
+//AsyncSend 异步发送，不会因为对端接收慢而阻塞，队列已满时按Options.SendQueuePolicy处理
+func (c *routerProtocol) AsyncSend(msgID uint32, bs []byte) error {
+	return c.asyncSend(c, msgID, bs)
+}
+
+//writePacket 发送已经封包好的数据
+func (c *routerProtocol) writePacket(dataPack []byte) (int, error) {
+
+	// websocket客户端，使用二进制帧发送
+	if c.ws != nil {
+		return c.ws.Binary(dataPack)
+	}
+
 	if c.GetTLSEnable() {
 		c.tlsWritePacketSize = len(dataPack)
 		return c.tlsLayer.Write(dataPack)
@@ -179,3 +261,89 @@ func (c *routerProtocol) Send(msgID uint32, bytes []byte) (int, error) {
 
 	return c.Write(dataPack)
 }
+
+//detect 根据最先读取到的数据判断是否为websocket握手请求，读取的数据会保留给后续解析
+func (c *routerProtocol) detect() error {
+
+	method := []byte("GET ")
+	buffer := make([]byte, len(method)-len(c.unread))
+	n, err := c.readData(buffer)
+	if n <= 0 {
+		if err == nil {
+			err = syscall.EAGAIN
+		}
+		return err
+	}
+	c.unread = append(c.unread, buffer[:n]...)
+
+	// 不是http请求，按路由协议处理
+	if !bytes.HasPrefix(method, c.unread) {
+		c.detected = true
+		return nil
+	}
+
+	// 数据还不够判断，等待下一次可读
+	if len(c.unread) < len(method) {
+		return syscall.EAGAIN
+	}
+
+	c.detected = true
+	c.ws = newWebsocketProtocol(c.BaseConnect).(*websocketProtocol)
+	c.ws.conn = c
+
+	// 读取到的数据可能已经是完整的握手请求，不会再触发可读事件
+	return nil
+}
+
+//decodeWebsocket websocket帧中的数据是封包后的数据，解包后交给路由处理
+func (c *routerProtocol) decodeWebsocket() (iface.IMessage, error) {
+
+	message, err := c.ws.DecodePacket()
+	if message == nil || err != nil {
+		return message, err
+	}
+
+	data := message.Bytes()
+	packer := c.GetPacker()
+	headerLength := int(packer.GetHeaderLength())
+	if len(data) < headerLength {
+		return nil, util.WebsocketPacketIncomplete
+	}
+
+	packet, err := packer.UnPack(data[:headerLength])
+	if err != nil {
+		return nil, err
+	}
+
+	body := data[headerLength:]
+	if len(body) < packet.Len() {
+		return nil, util.WebsocketPacketIncomplete
+	}
+	packet.SetData(body[:packet.Len()])
+
+	if err := decodeBody(packet); err != nil {
+		return nil, err
+	}
+
+	if c.isReplay(packet) {
+		return nil, nil
+	}
+
+	return packet, nil
+}
+
+//CloseCode 已升级为websocket时发送带code的close帧，否则直接关闭
+func (c *routerProtocol) CloseCode(code uint16, reason string) error {
+	if c.ws != nil {
+		return c.ws.CloseCode(code, reason)
+	}
+	return c.Close()
+}
+
+//GetQueryStringParam 已升级为websocket时，获取握手阶段传递过来的参数
+func (c *routerProtocol) GetQueryStringParam() url.Values {
+	if c.ws != nil {
+		return c.ws.GetQueryStringParam()
+	}
+	return c.BaseConnect.GetQueryStringParam()
+}
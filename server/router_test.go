@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//newPairConnect 创建一个不经过事件循环的routerProtocol，返回连接和对端的fd，向对端写入的数据可以直接通过DecodePacket读取
+func newPairConnect(t testing.TB, opts ...Option) (iface.IConnect, int) {
+	t.Helper()
+
+	options := parseOption(append([]Option{WithLogOutput(io.Discard)}, opts...)...)
+	if err := prepareOption(options); err != nil {
+		t.Fatal(err)
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.SetNonblock(fds[0], true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = unix.Close(fds[0])
+		_ = unix.Close(fds[1])
+	})
+
+	return newRouterProtocol(newBaseConnect(1, fds[0], nil, options)), fds[1]
+}
+
+//decodeAll 和边缘触发一样一直读取到EAGAIN，返回解出的所有消息
+func decodeAll(t *testing.T, connect iface.IConnect) []iface.IMessage {
+	t.Helper()
+
+	var messages []iface.IMessage
+	for {
+		message, err := connect.(iface.IConnectEvent).DecodePacket()
+		if err == unix.EAGAIN {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message != nil && message.Len() > 0 {
+			messages = append(messages, message)
+		}
+	}
+}
+
+//testFrames 生成长度不同的数据包，其中有比读取buffer更大的包
+func testFrames(t *testing.T, count int) ([][]byte, []byte) {
+	t.Helper()
+
+	var (
+		bodies [][]byte
+		stream bytes.Buffer
+	)
+	for i := 0; i < count; i++ {
+		body := bytes.Repeat([]byte(fmt.Sprintf("%03d", i)), i*7+1)
+		bodies = append(bodies, body)
+		stream.Write(packFrame(t, uint32(i+1), body))
+	}
+	return bodies, stream.Bytes()
+}
+
+//assertFrames 消息的顺序和内容与发送的一致
+func assertFrames(t *testing.T, messages []iface.IMessage, bodies [][]byte) {
+	t.Helper()
+
+	if len(messages) != len(bodies) {
+		t.Fatalf("decoded %d messages, want %d", len(messages), len(bodies))
+	}
+	for i, message := range messages {
+		if message.ID() != uint32(i+1) || !bytes.Equal(message.Bytes(), bodies[i]) {
+			t.Fatalf("message %d: id %d, %d bytes; want id %d, %d bytes", i, message.ID(), message.Len(), i+1, len(bodies[i]))
+		}
+	}
+}
+
+func TestDecodePacketByteByByte(t *testing.T) {
+	connect, peer := newPairConnect(t, WithReadBufferSize(64))
+	bodies, stream := testFrames(t, 30)
+
+	// 每次可读事件只有一个字节，包头和包体都会被拆分
+	var messages []iface.IMessage
+	for i := range stream {
+		if _, err := unix.Write(peer, stream[i:i+1]); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, decodeAll(t, connect)...)
+	}
+	assertFrames(t, messages, bodies)
+}
+
+func TestDecodePacketMultiFrameChunk(t *testing.T) {
+	connect, peer := newPairConnect(t, WithReadBufferSize(64))
+	bodies, stream := testFrames(t, 30)
+
+	// 一次可读事件中有多个完整的包，最后一个包只有一部分
+	split := len(stream) - 5
+	if _, err := unix.Write(peer, stream[:split]); err != nil {
+		t.Fatal(err)
+	}
+	messages := decodeAll(t, connect)
+	assertFrames(t, messages, bodies[:len(bodies)-1])
+
+	if _, err := unix.Write(peer, stream[split:]); err != nil {
+		t.Fatal(err)
+	}
+	assertFrames(t, append(messages, decodeAll(t, connect)...), bodies)
+}
+
+func TestDecodePacketHeaderOnly(t *testing.T) {
+	connect, peer := newPairConnect(t, WithMaxBodyLength(16<<20))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// 包头声明了16MB的包体，之后不再发送数据
+	head := make([]byte, 8)
+	binary.LittleEndian.PutUint32(head, 16<<20)
+	binary.LittleEndian.PutUint32(head[4:], 1)
+	if _, err := unix.Write(peer, head); err != nil {
+		t.Fatal(err)
+	}
+	if messages := decodeAll(t, connect); len(messages) != 0 {
+		t.Fatalf("decoded %d messages from a header", len(messages))
+	}
+
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= 1<<20 {
+		t.Fatalf("allocated %d bytes for a header without a body", allocated)
+	}
+}
+
+//BenchmarkDecodePacket 解出包体较大的消息，包体的内存随着数据到达增长
+func BenchmarkDecodePacket(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 20} {
+		size := size
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			connect, peer := newPairConnect(b)
+			frame := packFrame(b, 1, make([]byte, size))
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for written := 0; written < len(frame); {
+						n, err := unix.Write(peer, frame[written:])
+						if err != nil {
+							return
+						}
+						written += n
+					}
+				}()
+
+				// 和事件循环一样，可读时才读取
+				for {
+					message, err := connect.(iface.IConnectEvent).DecodePacket()
+					if err == unix.EAGAIN {
+						_, _ = unix.Poll([]unix.PollFd{{Fd: int32(connect.GetFd()), Events: unix.POLLIN}}, -1)
+						continue
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					if message != nil && message.Len() == size {
+						break
+					}
+				}
+				<-done
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ikilobyte/netman/common"
 	"github.com/ikilobyte/netman/iface"
@@ -13,6 +17,9 @@ type RouterMgr struct {
 	routeMiddleware   map[uint32][]iface.MiddlewareFunc // 路由中间件
 	globalMiddlewares []iface.MiddlewareFunc            // 全局中间件
 	middlewareGroup   []iface.IMiddlewareGroup
+	lock              sync.RWMutex             // 启动后仍可以添加、删除路由
+	concurrency       map[uint32]chan struct{} // 路由的并发限制
+	stats             *routeStats              // 路由的处理耗时统计
 }
 
 //NewRouterMgr 中间件执行顺序 globalMiddleware -> routerMiddleware
@@ -22,14 +29,45 @@ func NewRouterMgr() *RouterMgr {
 		routeMiddleware:   make(map[uint32][]iface.MiddlewareFunc),
 		globalMiddlewares: make([]iface.MiddlewareFunc, 0),
 		middlewareGroup:   make([]iface.IMiddlewareGroup, 0),
+		concurrency:       make(map[uint32]chan struct{}),
+		stats:             newRouteStats(),
 	}
 }
 
 //Add 添加路由
 func (r *RouterMgr) Add(msgID uint32, router iface.IRouter) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	r.inner[msgID] = router
 }
 
+//Remove 删除路由，以及这个路由的中间件
+func (r *RouterMgr) Remove(msgID uint32) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.inner, msgID)
+	delete(r.routeMiddleware, msgID)
+}
+
+//SetConcurrency 限制路由同时执行的数量，max <= 0 表示不限制
+func (r *RouterMgr) SetConcurrency(msgID uint32, max int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if max <= 0 {
+		delete(r.concurrency, msgID)
+		return
+	}
+	r.concurrency[msgID] = make(chan struct{}, max)
+}
+
+//Has 路由是否存在
+func (r *RouterMgr) Has(msgID uint32) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	_, ok := r.inner[msgID]
+	return ok
+}
+
 //NewGroup 中间一个中间件组
 func (r *RouterMgr) NewGroup(callable iface.MiddlewareFunc, more ...iface.MiddlewareFunc) iface.IMiddlewareGroup {
 	group := newMiddlewareGroup(append(more, callable)...)
@@ -41,7 +79,9 @@ func (r *RouterMgr) NewGroup(callable iface.MiddlewareFunc, more ...iface.Middle
 func (r *RouterMgr) ResolveGroup() error {
 	for _, group := range r.middlewareGroup {
 		for routerID, router := range group.GetRouters() {
+			r.lock.Lock()
 			r.routeMiddleware[routerID] = group.GetMiddlewares()
+			r.lock.Unlock()
 			r.Add(routerID, router)
 		}
 	}
@@ -51,7 +91,9 @@ func (r *RouterMgr) ResolveGroup() error {
 //Get 根据msgID获取路由
 func (r *RouterMgr) Get(msgID uint32) (iface.IRouter, error) {
 
+	r.lock.RLock()
 	router, ok := r.inner[msgID]
+	r.lock.RUnlock()
 	if ok {
 		return router, nil
 	}
@@ -66,8 +108,34 @@ func (r *RouterMgr) Do(ctx iface.IContext) error {
 		return err
 	}
 
+	// 并发数已满时排队等待，请求的context取消后不再等待
+	r.lock.RLock()
+	semaphore := r.concurrency[request.GetMessage().ID()]
+	r.lock.RUnlock()
+	if semaphore != nil {
+		select {
+		case semaphore <- struct{}{}:
+			defer func() { <-semaphore }()
+		case <-request.Context().Done():
+			return request.Context().Err()
+		}
+	}
+
+	// 统计耗时，panic时也会记录
+	start := time.Now()
+	failed := true
+	defer func() {
+		r.stats.record(request.GetMessage().ID(), time.Since(start), failed)
+	}()
+
 	// 执行方法
-	router.Do(request)
+	if handler, ok := router.(iface.IResponseRouter); ok {
+		handler.DoResponse(request, ctx.GetResponse())
+	} else {
+		router.Do(request)
+	}
+
+	failed = false
 	return nil
 }
 
@@ -76,6 +144,34 @@ func (r *RouterMgr) Dispatch(ctx iface.IContext, options *Options) {
 
 	request := ctx.GetRequest()
 
+	// 单个路由的panic不能影响整个服务
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			options.Logger.WithFields(map[string]interface{}{
+				"requestID": request.ID(),
+				"msgID":     request.GetMessage().ID(),
+				"connID":    request.GetConnect().GetID(),
+				"stack":     string(debug.Stack()),
+			}).Errorf("router panic: %v", recovered)
+
+			if options.OnPanic != nil {
+				options.OnPanic(request, recovered)
+			}
+		}
+	}()
+
+	// 处理超时，取消context并记录日志，IContextRouter可以感知到并提前退出
+	if timeout := options.HandlerTimeout; timeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+		request.SetContext(timeoutCtx)
+
+		timer := time.AfterFunc(timeout, func() {
+			options.Logger.Warnf("requestID[%d] msgID[%d] of connID[%d] handler timeout %v", request.ID(), request.GetMessage().ID(), request.GetConnect().GetID(), timeout)
+		})
+		defer timer.Stop()
+	}
+
 	// 合并中间件
 	middlewares := make([]iface.MiddlewareFunc, 0)
 
@@ -83,7 +179,9 @@ func (r *RouterMgr) Dispatch(ctx iface.IContext, options *Options) {
 	middlewares = append(middlewares, r.globalMiddlewares...)
 
 	// 路由中间件
+	r.lock.RLock()
 	middlewares = append(middlewares, r.routeMiddleware[request.GetMessage().ID()]...)
+	r.lock.RUnlock()
 
 	// 先执行中间件
 	util.NewPipeline().
@@ -99,15 +197,38 @@ func (r *RouterMgr) Dispatch(ctx iface.IContext, options *Options) {
 				return err
 			}
 
-			// TCP协议
-			if err = r.Do(ctx); err != nil {
-				util.Logger.Infoln(fmt.Errorf("do handler err %s", err))
-			}
+			// 路由、NotFoundHandler中SendNoFlush缓冲的数据，处理完毕后统一发送
+			defer func() {
+				_ = request.GetConnect().Flush()
+			}()
 
+			// TCP协议，未匹配到路由时交给NotFoundHandler处理，未配置时记录日志，方便排查协议错误
+			if err = r.Do(ctx); err == util.RouterNotFound {
+				if options.NotFoundHandler != nil {
+					options.NotFoundHandler.Do(request)
+					return nil
+				}
+				options.Logger.Warnf("requestID[%d] msgID[%d] of connID[%d] router not found", request.ID(), request.GetMessage().ID(), request.GetConnect().GetID())
+				return err
+			}
+			if err != nil {
+				options.Logger.Infof("requestID[%d] do handler err %s", request.ID(), err)
+			}
 			return err
 		})
 }
 
+//DispatchBatch 按顺序分发批量投递的消息，心跳的pong和逐个投递时一样不会分发到路由
+func (r *RouterMgr) DispatchBatch(batch *util.Batch, options *Options) {
+	for _, ctx := range batch.Contexts {
+		if options.Heartbeat.isPong(ctx) {
+			continue
+		}
+		r.Dispatch(ctx, options)
+		atomic.AddUint64(&options.counters.messages, 1)
+	}
+}
+
 //Conversion 将中间件转换为stage类型
 func (r *RouterMgr) Conversion(middlewares []iface.MiddlewareFunc) []iface.IStage {
 	stages := make([]iface.IStage, 0)
@@ -126,3 +247,33 @@ func (s *stage) Process(value interface{}, next iface.NextFunc) interface{} {
 		return next(ctx)
 	})
 }
+
+//ContextRouter 将IContextRouter转换为IRouter，可用于分组路由
+func ContextRouter(router iface.IContextRouter) iface.IRouter {
+	return &contextRouter{router}
+}
+
+type contextRouter struct {
+	router iface.IContextRouter
+}
+
+//Do 每个请求都会派生一个新的context，处理完毕后取消
+func (c *contextRouter) Do(request iface.IRequest) {
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	c.router.DoContext(ctx, request)
+}
+
+//ResponseRouter 将IResponseRouter转换为IRouter，可用于分组路由
+func ResponseRouter(router iface.IResponseRouter) iface.IRouter {
+	return &responseRouter{router}
+}
+
+type responseRouter struct {
+	iface.IResponseRouter
+}
+
+//Do 未经过RouterMgr调用时，创建一个新的响应对象
+func (r *responseRouter) Do(request iface.IRequest) {
+	r.DoResponse(request, util.NewResponse(request))
+}
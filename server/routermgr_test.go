@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//notFoundRouter 用SendNoFlush回复一个错误包
+type notFoundRouter struct{}
+
+func (*notFoundRouter) Do(request iface.IRequest) {
+	_ = request.GetConnect().SendNoFlush(0, []byte("not found"))
+}
+
+func TestNotFoundHandlerFlush(t *testing.T) {
+	s := startServer(t, WithNotFoundHandler(new(notFoundRouter)))
+	conn := dial(t, s)
+
+	if _, err := conn.Write(packFrame(t, 404, []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	message, err := readFrame(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ID() != 0 || string(message.Bytes()) != "not found" {
+		t.Fatalf("got msgID[%d] %q", message.ID(), message.Bytes())
+	}
+}
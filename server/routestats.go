@@ -0,0 +1,121 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+//routeStatBuckets 耗时分布的桶，第i个桶的上限为 1µs << i，最后一个桶约为2.4小时
+const routeStatBuckets = 34
+
+//RouteStatBucketBound 第i个耗时分布桶的上限
+func RouteStatBucketBound(i int) time.Duration {
+	return time.Microsecond << uint(i)
+}
+
+//RouteStat 单个路由的处理耗时统计
+type RouteStat struct {
+	Count   uint64        // 处理的消息数量
+	Errors  uint64        // 处理失败的数量，如：panic
+	Total   time.Duration // 累计耗时
+	Avg     time.Duration // 平均耗时
+	P99     time.Duration // 99%的消息处理耗时不超过这个值，按桶估算，精度为2倍
+	Max     time.Duration // 最大耗时
+	Buckets []uint64      // 耗时分布，第i个元素为耗时在(RouteStatBucketBound(i-1), RouteStatBucketBound(i)]之间的数量，可以用来生成直方图
+}
+
+//routeStat 单个路由的原始数据
+type routeStat struct {
+	count   uint64
+	errors  uint64
+	total   time.Duration
+	max     time.Duration
+	buckets [routeStatBuckets]uint64
+}
+
+//routeStats 所有路由的耗时统计，key为msgID
+type routeStats struct {
+	inner map[uint32]*routeStat
+	lock  sync.Mutex
+}
+
+//newRouteStats .
+func newRouteStats() *routeStats {
+	return &routeStats{inner: make(map[uint32]*routeStat)}
+}
+
+//record 记录一次处理的耗时
+func (r *routeStats) record(msgID uint32, elapsed time.Duration, failed bool) {
+
+	bucket := 0
+	for bucket < routeStatBuckets-1 && elapsed > RouteStatBucketBound(bucket) {
+		bucket++
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stat, ok := r.inner[msgID]
+	if !ok {
+		stat = &routeStat{}
+		r.inner[msgID] = stat
+	}
+
+	stat.count++
+	stat.total += elapsed
+	stat.buckets[bucket]++
+	if elapsed > stat.max {
+		stat.max = elapsed
+	}
+	if failed {
+		stat.errors++
+	}
+}
+
+//snapshot 获取所有路由的统计
+func (r *routeStats) snapshot() map[uint32]RouteStat {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stats := make(map[uint32]RouteStat, len(r.inner))
+	for msgID, stat := range r.inner {
+		item := RouteStat{
+			Count:   stat.count,
+			Errors:  stat.errors,
+			Total:   stat.total,
+			Max:     stat.max,
+			Buckets: append([]uint64(nil), stat.buckets[:]...),
+		}
+		if stat.count > 0 {
+			item.Avg = stat.total / time.Duration(stat.count)
+		}
+
+		// 累计到99%的桶
+		threshold := (stat.count*99 + 99) / 100
+		var cumulative uint64
+		for i, n := range stat.buckets {
+			cumulative += n
+			if cumulative >= threshold {
+				item.P99 = RouteStatBucketBound(i)
+				break
+			}
+		}
+		if item.P99 > item.Max {
+			item.P99 = item.Max
+		}
+
+		stats[msgID] = item
+	}
+	return stats
+}
+
+//RouteStats 每个路由的处理耗时统计，key为msgID，可以用来找出处理较慢的消息
+func (s *Server) RouteStats() map[uint32]RouteStat {
+	return s.routerMgr.stats.snapshot()
+}
+
+//RouteStats 每个路由的处理耗时统计，key为msgID
+func (s *UDPServer) RouteStats() map[uint32]RouteStat {
+	return s.routerMgr.stats.snapshot()
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//fairScheduler 公平调度，每个连接一个消息队列，固定数量的worker按连接轮流取出消息处理
+//同一个连接同时只会有一个消息在处理，消息再多也只会占用一个worker，不会让其他连接一直排队
+//每个连接最多排队EmitChanSize个消息，超过后按EmitPolicy处理，和消息队列已满时一样
+type fairScheduler struct {
+	server *Server
+	queues map[uint64]*connectQueue // connID => 等待处理的消息
+	ready  []uint64                 // 有消息等待处理、且没有消息正在处理的连接，按顺序轮流处理
+	lock   sync.Mutex               // 保护queues、ready
+	cond   *sync.Cond               // 有新的连接进入ready时通知worker
+	space  *sync.Cond               // 连接的队列有空位时通知push
+	limit  int                      // 每个连接最多排队的消息数
+	policy common.EmitPolicy        // 连接的队列已满时的处理方式
+	closed bool                     // 已停止，worker退出
+}
+
+//connectQueue 单个连接等待处理的消息
+type connectQueue struct {
+	contexts  []iface.IContext
+	scheduled bool // 已经在ready中或者正在处理
+}
+
+//newFairScheduler 创建并启动num个worker
+func newFairScheduler(server *Server, num int) *fairScheduler {
+	scheduler := &fairScheduler{
+		server: server,
+		queues: make(map[uint64]*connectQueue),
+		limit:  server.options.EmitChanSize,
+		policy: server.options.EmitPolicy,
+	}
+	if scheduler.limit <= 0 {
+		scheduler.limit = 1
+	}
+	scheduler.cond = sync.NewCond(&scheduler.lock)
+	scheduler.space = sync.NewCond(&scheduler.lock)
+
+	for i := 0; i < num; i++ {
+		go scheduler.work()
+	}
+	return scheduler
+}
+
+//push 放入连接的消息队列，连接还没有在等待时排到ready的最后
+func (f *fairScheduler) push(context iface.IContext) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id := context.GetConnect().GetID()
+	queue := f.queueOf(id)
+
+	// 队列已满，阻塞时doMessage不再读取消息队列，压力传递回事件循环
+	for len(queue.contexts) >= f.limit && !f.closed {
+		if f.policy == common.EmitBlock {
+			f.space.Wait()
+
+			// 等待期间消息可能已全部处理完，队列被删除
+			queue = f.queueOf(id)
+			continue
+		}
+
+		// 丢弃并关闭连接，关闭时会执行OnClose，不能阻塞doMessage
+		if f.policy == common.EmitRejectClose {
+			f.server.options.Logger.Warnf("message queue of connID[%d] is full, close", id)
+			f.release(1)
+			go closeWith(context.GetConnect(), common.CloseRejected)
+			return
+		}
+
+		// 丢弃这个连接最早的消息
+		oldest := queue.contexts[0]
+		queue.contexts[0] = nil
+		queue.contexts = queue.contexts[1:]
+		f.release(1)
+		f.server.options.Logger.Warnf("message queue of connID[%d] is full, drop msgID[%d]", id, oldest.GetMessage().ID())
+	}
+
+	// 已停止，不会再有worker处理
+	if f.closed {
+		f.release(1)
+		return
+	}
+	queue.contexts = append(queue.contexts, context)
+
+	if !queue.scheduled {
+		queue.scheduled = true
+		f.ready = append(f.ready, id)
+		f.cond.Signal()
+	}
+}
+
+//queueOf 连接的消息队列，不存在时创建，调用方需要持有锁
+func (f *fairScheduler) queueOf(id uint64) *connectQueue {
+	queue, ok := f.queues[id]
+	if !ok {
+		queue = new(connectQueue)
+		f.queues[id] = queue
+	}
+	return queue
+}
+
+//next 取出下一个连接的第一个消息，已停止时返回false
+func (f *fairScheduler) next() (uint64, iface.IContext, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for len(f.ready) == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.closed {
+		return 0, nil, false
+	}
+
+	id := f.ready[0]
+	f.ready[0] = 0
+	f.ready = f.ready[1:]
+
+	queue := f.queues[id]
+	context := queue.contexts[0]
+	queue.contexts[0] = nil
+	queue.contexts = queue.contexts[1:]
+	f.space.Broadcast()
+	return id, context, true
+}
+
+//done 连接的一个消息处理完毕，还有消息时重新排到ready的最后
+func (f *fairScheduler) done(id uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	queue := f.queues[id]
+	if len(queue.contexts) == 0 {
+		delete(f.queues, id)
+		return
+	}
+	f.ready = append(f.ready, id)
+	f.cond.Signal()
+}
+
+//work 循环取出消息处理，和doMessage中一样计入wg、inflight
+func (f *fairScheduler) work() {
+	s := f.server
+	for {
+		id, context, ok := f.next()
+		if !ok {
+			return
+		}
+
+		if batch, ok := context.(*util.Batch); ok {
+			s.routerMgr.DispatchBatch(batch, s.options)
+		} else {
+			s.routerMgr.Dispatch(context, s.options)
+			atomic.AddUint64(&s.options.counters.messages, 1)
+		}
+
+		atomic.AddInt64(&s.inflight, -1)
+		s.wg.Done()
+		f.done(id)
+	}
+}
+
+//stop 通知所有worker退出，队列中还未处理的消息会被丢弃
+func (f *fairScheduler) stop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closed = true
+	f.cond.Broadcast()
+	f.space.Broadcast()
+
+	// Shutdown超时后doMessage可能还在等待wg，丢弃的消息也需要计入
+	for _, queue := range f.queues {
+		f.release(len(queue.contexts))
+		queue.contexts = nil
+	}
+}
+
+//release 丢弃了n个消息，和处理完毕一样减去wg、inflight
+func (f *fairScheduler) release(n int) {
+	for i := 0; i < n; i++ {
+		atomic.AddInt64(&f.server.inflight, -1)
+		f.server.wg.Done()
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+)
+
+//seqPacker 开启了序列号的默认封包方式
+func seqPacker() *util.DataPacker {
+	packer := util.NewDataPacker()
+	packer.SetSequence(true)
+	return packer
+}
+
+func TestBroadcastSequence(t *testing.T) {
+	connected := make(chan iface.IConnect, 2)
+	s := startServer(t,
+		WithSequence(0),
+		WithOnConnect(func(connect iface.IConnect) {
+			connected <- connect
+		}),
+	)
+
+	conns := []net.Conn{dial(t, s), dial(t, s)}
+	for range conns {
+		select {
+		case <-connected:
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect not called")
+		}
+	}
+
+	// 每个连接的序列号各自从1开始递增
+	for i := 0; i < 3; i++ {
+		if err := s.Broadcast(1, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, conn := range conns {
+		for want := uint64(1); want <= 3; want++ {
+			message, err := readFrameWith(conn, seqPacker(), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seq := message.(iface.ISeqMessage).Seq(); seq != want {
+				t.Fatalf("broadcast seq %d, want %d", seq, want)
+			}
+		}
+	}
+}
+
+func TestSequenceDedupRejectsZero(t *testing.T) {
+	router := new(countRouter)
+	s := startServer(t, WithSequence(16))
+	s.AddRouter(1, router)
+	conn := dial(t, s)
+
+	packer := seqPacker()
+	send := func(seq uint64) {
+		t.Helper()
+		frame, err := packer.PackWithSeq(1, seq, []byte("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 未设置序列号、重放的消息都被丢弃
+	send(0)
+	send(1)
+	send(1)
+	send(0)
+	send(2)
+
+	waitFor(t, time.Second, func() bool {
+		return atomic.LoadInt64(&router.count) >= 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&router.count); n != 2 {
+		t.Fatalf("handled %d messages, want 2", n)
+	}
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"net"
 	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ikilobyte/netman/common"
 
@@ -16,7 +19,7 @@ import (
 	"github.com/ikilobyte/netman/iface"
 )
 
-type serverStatus = int
+type serverStatus = int32
 
 const (
 	stopped  serverStatus = iota // 已停止（初始状态）
@@ -27,7 +30,7 @@ const (
 type Server struct {
 	ip         string
 	port       int
-	status     serverStatus          // 状态
+	status     serverStatus          // 状态，通过atomic读写
 	options    *Options              // serve启动可选项参数
 	socket     *socket               // 直接系统调用的方式监听TCP端口，不使用官方的net包
 	acceptor   iface.IAcceptor       // 处理新连接
@@ -36,10 +39,26 @@ type Server struct {
 	packer     iface.IPacker         // 负责封包解包
 	emitCh     chan iface.IContext   // 从这里接收epoll转发过来的消息，然后交给worker去处理
 	routerMgr  *RouterMgr            // 路由统一管理
+	groupMgr   *ConnectGroupMgr      // 连接分组管理
+	wg         sync.WaitGroup        // 正在处理中的消息
+	inflight   int64                 // 正在处理中的消息数量，通过atomic读写，用于判断是否过载
+	scheduler  *fairScheduler        // 开启FairScheduling时按连接轮流处理消息
+	drained    chan struct{}         // Shutdown时，队列中的消息全部处理完毕后关闭
+	done       chan struct{}         // 停止时关闭，通知doMessage退出，emitCh不会被关闭
+	cancel     context.CancelFunc    // 取消服务的context
+	listeners  []*socket             // Listen添加的其他监听地址
+	listenLock sync.Mutex            // 保护listeners
 }
 
 //makeServer 创建tcp server服务器
-func createTcpServer(ip string, port int, opts ...Option) (*Server, *Options) {
+func createTcpServer(ip string, port int, opts ...Option) (*Server, *Options, error) {
+	return createServer(ip, port, func(options *Options) (*socket, error) {
+		return createSocket(joinHostPort(ip, port), options)
+	}, opts...)
+}
+
+//createServer 创建server，listen负责创建监听的socket
+func createServer(ip string, port int, listen func(options *Options) (*socket, error), opts ...Option) (*Server, *Options, error) {
 
 	options := parseOption(opts...)
 
@@ -48,54 +67,111 @@ func createTcpServer(ip string, port int, opts ...Option) (*Server, *Options) {
 		options.NumEventLoop = runtime.NumCPU()
 	}
 
-	// 封包解包的实现层，外部可以自行实现IPacker使用自己的封包解包方式
-	if options.Packer == nil {
-		options.Packer = util.NewDataPacker()
-		options.Packer.SetMaxBodyLength(options.MaxBodyLength)
+	// 默认值
+	if err := prepareOption(options); err != nil {
+		return nil, options, err
 	}
 
-	// 日志保存路径
-	if options.LogOutput != nil {
-		util.Logger.SetOutput(options.LogOutput)
+	// 监听端口
+	sock, err := listen(options)
+	if err != nil {
+		return nil, options, err
 	}
 
+	// 服务的生命周期
+	ctx, cancel := context.WithCancel(options.ctx)
+	options.ctx = ctx
+
+	groupMgr := newConnectGroupMgr(options.Packer)
+
 	// 初始化
 	server := &Server{
 		ip:         ip,
 		port:       port,
 		options:    options,
 		status:     stopped,
-		socket:     createSocket(fmt.Sprintf("%s:%d", ip, port), options.TCPKeepAlive),
-		eventloop:  eventloop.NewEventLoop(options.NumEventLoop),
-		connectMgr: newConnectManager(options),
-		emitCh:     make(chan iface.IContext, 128),
+		socket:     sock,
+		eventloop:  eventloop.NewEventLoop(options.NumEventLoop, options.LoopBalancer, options.Logger),
+		connectMgr: newConnectManager(options, groupMgr),
+		emitCh:     make(chan iface.IContext, options.EmitChanSize),
 		packer:     options.Packer,
 		routerMgr:  NewRouterMgr(),
+		groupMgr:   groupMgr,
+		drained:    make(chan struct{}),
+		done:       make(chan struct{}),
+		cancel:     cancel,
 	}
 
 	// 初始化epoll
 	if err := server.eventloop.Init(server.connectMgr); err != nil {
-		log.Panicln(err)
+		server.closeSocket()
+		cancel()
+		return nil, options, err
+	}
+
+	acceptor, err := newAcceptor(server.packer, server.connectMgr, options)
+	if err != nil {
+		server.eventloop.Stop()
+		server.closeSocket()
+		cancel()
+		return nil, options, err
+	}
+	server.acceptor = acceptor
+
+	// 消息队列已满时的处理方式、日志、触发方式、超时时间、错误回调
+	if loop, ok := server.eventloop.(*eventloop.EventLoop); ok {
+		loop.EmitPolicy = options.EmitPolicy
+		loop.EdgeTriggered = options.EpollEdgeTriggered
+		loop.BatchDispatch = options.BatchDispatch
+		loop.WaitTimeout = options.EpollWaitTimeout
+		loop.OnError = options.OnError
 	}
 
 	// 执行wait
 	server.eventloop.Start(server.emitCh)
-	server.acceptor = newAcceptor(
-		server.packer,
-		server.connectMgr,
-		options,
-	)
 
 	// 处理消息
+	if options.FairScheduling {
+		server.scheduler = newFairScheduler(server, options.NumWorker)
+	}
 	go server.doMessage()
 
-	return server, options
+	return server, options, nil
 }
 
-//New 创建Server
+//New 创建Server，创建失败时会panic，需要处理错误时使用NewWithError
 func New(ip string, port int, opts ...Option) *Server {
+	server, err := NewWithError(ip, port, opts...)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return server
+}
+
+//NewWithError 创建Server，端口被占用、epoll创建失败等错误会返回，不会panic
+func NewWithError(ip string, port int, opts ...Option) (*Server, error) {
 
-	server, options := createTcpServer(ip, port, opts...)
+	server, options, err := createTcpServer(ip, port, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 应用层协议模式
+	options.Application = common.RouterMode
+
+	return server, nil
+}
+
+//NewUnix 创建一个监听unix domain socket的Server，适用于同一台机器上的进程间通信
+//Stop时会删除socket文件
+func NewUnix(path string, opts ...Option) *Server {
+
+	server, options, err := createServer(path, 0, func(options *Options) (*socket, error) {
+		return createUnixSocket(path, options.ListenBacklog)
+	}, opts...)
+	if err != nil {
+		log.Panicln(err)
+	}
 
 	// 应用层协议模式
 	options.Application = common.RouterMode
@@ -103,9 +179,91 @@ func New(ip string, port int, opts ...Option) *Server {
 	return server
 }
 
+//NewFromFD 使用已经在监听的fd创建Server，如：systemd socket activation、父进程传递过来的fd，可用于不停机重启
+//Stop时会关闭这个fd，unix domain socket不会删除socket文件
+func NewFromFD(fd int, opts ...Option) (*Server, error) {
+
+	// 必须是已经调用过listen的socket
+	accepting, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
+	if err != nil {
+		return nil, err
+	}
+	if accepting != 1 {
+		return nil, util.NotListenerFD
+	}
+
+	// 监听的地址
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, port := "", 0
+	switch addr := util.SockaddrToTCPOrUnixAddr(sa).(type) {
+	case *net.TCPAddr:
+		ip, port = addr.IP.String(), addr.Port
+	case *net.UnixAddr:
+		ip = addr.Name
+	}
+
+	server, options, err := createServer(ip, port, func(options *Options) (*socket, error) {
+		unix.CloseOnExec(fd)
+
+		// 继承的fd可能是阻塞的，同createSocket
+		if err := unix.SetNonblock(fd, true); err != nil {
+			return nil, err
+		}
+		return &socket{fd: fd, socketId: -1, addr: listenerAddr(fd)}, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 应用层协议模式
+	options.Application = common.RouterMode
+
+	return server, nil
+}
+
+//ListenerFD 监听的fd，可以传递给子进程，子进程通过NewFromFD继续接收新连接
+func (s *Server) ListenerFD() int {
+	return s.socket.fd
+}
+
+//Addr 主监听的地址，端口为0时可以获取系统分配的端口，TestServer返回nil
+func (s *Server) Addr() net.Addr {
+	return s.socket.addr
+}
+
+//Listen 额外监听一个地址，和主监听地址共用事件循环、路由、连接管理，可以通过LocalAddr区分连接来自哪个地址
+//Start之前或之后都可以调用，Stop时一起关闭；ListenerFD、Fork只处理主监听的fd
+func (s *Server) Listen(ip string, port int) error {
+	if atomic.LoadInt32(&s.status) == stopping {
+		return util.ServerStopped
+	}
+
+	sock, err := createSocket(joinHostPort(ip, port), s.options)
+	if err != nil {
+		return err
+	}
+
+	s.listenLock.Lock()
+	defer s.listenLock.Unlock()
+
+	if err := s.acceptor.AddListener(sock.fd); err != nil {
+		_ = unix.Close(sock.fd)
+		return err
+	}
+	s.listeners = append(s.listeners, sock)
+	return nil
+}
+
 //Websocket 创建一个websocket server
 func Websocket(ip string, port int, handler iface.IWebsocketHandler, opts ...Option) *Server {
-	server, options := createTcpServer(ip, port, opts...)
+	server, options, err := createTcpServer(ip, port, opts...)
+	if err != nil {
+		log.Panicln(err)
+	}
 
 	// 应用层协议模式
 	options.Application = common.WebsocketMode
@@ -126,43 +284,111 @@ func (s *Server) AddRouter(msgID uint32, router iface.IRouter) {
 	s.routerMgr.Add(msgID, router)
 }
 
-//Start 启动
-func (s *Server) Start() {
-	if s.status != stopped {
-		return
+//RemoveRouter 删除路由，启动后也可以调用，之后收到的这个msgID的消息会交给NotFoundHandler处理
+func (s *Server) RemoveRouter(msgID uint32) {
+	s.routerMgr.Remove(msgID)
+}
+
+//SetRouteConcurrency 限制某个路由同时执行的数量，超过时排队等待，不影响其他路由，max <= 0 表示不限制
+func (s *Server) SetRouteConcurrency(msgID uint32, max int) {
+	s.routerMgr.SetConcurrency(msgID, max)
+}
+
+//HasRouter 路由是否存在
+func (s *Server) HasRouter(msgID uint32) bool {
+	return s.routerMgr.Has(msgID)
+}
+
+//AddResponseRouter 添加带响应对象的路由，中间件可以通过ctx.GetResponse()检查、修改响应
+func (s *Server) AddResponseRouter(msgID uint32, router iface.IResponseRouter) {
+	s.AddRouter(msgID, ResponseRouter(router))
+}
+
+//AddContextRouter 添加带context的路由，连接关闭或服务关闭时ctx会被取消
+func (s *Server) AddContextRouter(msgID uint32, router iface.IContextRouter) {
+	s.AddRouter(msgID, ContextRouter(router))
+}
+
+//Start 启动，会一直阻塞，直到Server停止或listener出现不可恢复的错误
+func (s *Server) Start() error {
+	if !atomic.CompareAndSwapInt32(&s.status, stopped, started) {
+
+		// 已停止的Server不能再次启动，监听的socket已经关闭
+		if atomic.LoadInt32(&s.status) == stopping {
+			return util.ServerStopped
+		}
+		return nil
 	}
-	s.status = started
 
 	// 处理路由分组的数据
 	if err := s.routerMgr.ResolveGroup(); err != nil {
-		util.Logger.Errorf("server start error：%v", err)
+		return err
 	}
 
-	if err := s.acceptor.Run(s.socket.fd, s.eventloop); err != nil {
-		util.Logger.Errorf("server start error：%v", err)
-	}
+	return s.acceptor.Run(s.socket.fd, s.eventloop)
 }
 
 //doMessage 处理消息
 func (s *Server) doMessage() {
 	for {
 		select {
-		case context, ok := <-s.emitCh:
+		case <-s.done:
+			// 已停止，emitCh中剩余的消息不再处理
+			return
+		case context := <-s.emitCh:
+
+			// Shutdown投递的结束标记，在此之前的消息都已分发出去，等待全部处理完毕
+			if context == nil {
+				s.wg.Wait()
+				close(s.drained)
+				continue
+			}
+
+			// 过载时按OverloadPolicy拒绝或丢弃
+			if s.shed(context) {
+				continue
+			}
+
+			// 心跳的pong不需要分发到路由，批量投递的在DispatchBatch中判断
+			batch, isBatch := context.(*util.Batch)
+			if !isBatch && s.options.Heartbeat.isPong(context) {
+				continue
+			}
+
+			s.wg.Add(1)
+			atomic.AddInt64(&s.inflight, 1)
+
+			// 公平调度，由固定数量的worker按连接轮流处理
+			if s.scheduler != nil {
+				s.scheduler.push(context)
+				continue
+			}
 
-			// 通道已关闭
-			if !ok {
-				return
+			// 批量投递的消息在一个协程中按顺序处理
+			if isBatch {
+				go func() {
+					defer s.wg.Done()
+					defer atomic.AddInt64(&s.inflight, -1)
+					s.routerMgr.DispatchBatch(batch, s.options)
+				}()
+				continue
 			}
 
 			// 分发出去
-			go s.routerMgr.Dispatch(context, s.options)
+			go func(ctx iface.IContext) {
+				defer s.wg.Done()
+				defer atomic.AddInt64(&s.inflight, -1)
+				defer atomic.AddUint64(&s.options.counters.messages, 1)
+				s.routerMgr.Dispatch(ctx, s.options)
+			}(context)
 		}
 	}
 }
 
-//Use 全局中间件
-func (s *Server) Use(callable iface.MiddlewareFunc) *Server {
+//Use 全局中间件，按注册顺序执行
+func (s *Server) Use(callable iface.MiddlewareFunc, more ...iface.MiddlewareFunc) *Server {
 	s.routerMgr.globalMiddlewares = append(s.routerMgr.globalMiddlewares, callable)
+	s.routerMgr.globalMiddlewares = append(s.routerMgr.globalMiddlewares, more...)
 	return s
 }
 
@@ -171,12 +397,121 @@ func (s *Server) Group(callable iface.MiddlewareFunc, more ...iface.MiddlewareFu
 	return s.routerMgr.NewGroup(callable, more...)
 }
 
-//Stop 停止
+//Broadcast 给所有连接推送同一条消息，只会封包一次，仅路由模式可用
+//部分连接发送失败时返回util.BroadcastError，包含失败的连接ID
+func (s *Server) Broadcast(msgID uint32, data []byte) error {
+	if s.options.Application != common.RouterMode {
+		return util.ApplicationNotRouterMode
+	}
+	return broadcast(s.packer, msgID, data, s.connectMgr.GetConnects())
+}
+
+//BroadcastFilter 给filter返回true的连接推送消息，只会封包一次，如：排除发送者、只推送给已登录的连接，仅路由模式可用
+//部分连接发送失败时返回util.BroadcastError
+func (s *Server) BroadcastFilter(msgID uint32, data []byte, filter func(conn iface.IConnect) bool) error {
+	if s.options.Application != common.RouterMode {
+		return util.ApplicationNotRouterMode
+	}
+	return broadcast(s.packer, msgID, data, s.FindConnections(filter))
+}
+
+//SendToConn 给指定ID的连接推送消息，连接不存在时返回util.ConnectNotFound
+func (s *Server) SendToConn(connID uint64, msgID uint32, data []byte) error {
+	connect, ok := s.connectMgr.GetByID(connID)
+	if !ok {
+		return util.ConnectNotFound
+	}
+
+	_, err := connect.Send(msgID, data)
+	return err
+}
+
+//RangeConnections 遍历所有连接，callable返回false时停止，可以在callable中关闭连接
+func (s *Server) RangeConnections(callable func(conn iface.IConnect) bool) {
+	s.connectMgr.Range(callable)
+}
+
+//ConnectGroup 获取连接分组，最后一个连接离开后分组会被删除，连接关闭后会自动离开所有分组
+func (s *Server) ConnectGroup(name string) *ConnectGroup {
+	return s.groupMgr.Get(name)
+}
+
+//PauseAccept 暂停接收新连接，已有的连接不受影响，可用于维护期间或从负载均衡中摘除
+func (s *Server) PauseAccept() error {
+	return s.acceptor.Pause()
+}
+
+//ResumeAccept 恢复接收新连接
+func (s *Server) ResumeAccept() error {
+	return s.acceptor.Resume()
+}
+
+//Stop 停止，重复调用、和Shutdown同时调用都不会有影响，顺序见teardown
 func (s *Server) Stop() {
-	s.status = stopping
-	s.connectMgr.ClearAll()
-	s.eventloop.Stop()
-	close(s.emitCh)
-	_ = unix.Close(s.socket.fd)
+	if atomic.SwapInt32(&s.status, stopping) == stopping {
+		return
+	}
 	s.acceptor.Exit()
+	s.cancel()
+	s.teardown()
+}
+
+//Shutdown 优雅关闭，不再接收新连接，等待队列中的消息处理完毕后才关闭事件循环和所有连接
+//ctx超时后仍会强制关闭，并返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	if atomic.SwapInt32(&s.status, stopping) == stopping {
+		return nil
+	}
+
+	// 不再接收新连接
+	s.acceptor.Exit()
+
+	// 投递结束标记，等待标记之前的消息处理完毕，处理期间ctx不会被取消
+	var err error
+	select {
+	case s.emitCh <- nil:
+		select {
+		case <-s.drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// 处理完毕或已超时，通知还在处理的路由尽快退出
+	s.cancel()
+	s.teardown()
+	return err
+}
+
+//teardown 调用之前已经停止了accept，之后的顺序：
+//1、停止事件循环，不再产生新的消息，阻塞在emitCh上的发送会直接返回
+//2、通知doMessage、公平调度的worker退出，emitCh不会被关闭，避免事件循环向已关闭的通道发送消息导致panic
+//3、关闭所有连接，执行OnClose
+//4、关闭监听的socket
+func (s *Server) teardown() {
+	s.eventloop.Stop()
+	close(s.done)
+	if s.scheduler != nil {
+		s.scheduler.stop()
+	}
+	s.connectMgr.ClearAll()
+	s.closeSocket()
+}
+
+//closeSocket 关闭监听的socket，包括Listen添加的
+func (s *Server) closeSocket() {
+	s.listenLock.Lock()
+	sockets := append([]*socket{s.socket}, s.listeners...)
+	s.listenLock.Unlock()
+
+	for _, sock := range sockets {
+		_ = unix.Close(sock.fd)
+
+		// unix domain socket需要删除socket文件
+		if sock.path != "" {
+			_ = unix.Unlink(sock.path)
+		}
+	}
 }
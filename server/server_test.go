@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/util"
+)
+
+func TestStartStopNoGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		s, err := NewWithError("127.0.0.1", 0, WithLogOutput(io.Discard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.AddRouter(1, new(echoRouter))
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Start()
+		}()
+
+		conn := dial(t, s)
+		if _, err := conn.Write(packFrame(t, 1, []byte("ping"))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readFrame(conn, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+
+		s.Stop()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Start returned %v after Stop", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Start did not return after Stop")
+		}
+
+		if err := s.Start(); err != util.ServerStopped {
+			t.Fatalf("Start after Stop returned %v, want ServerStopped", err)
+		}
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	s, err := NewWithError("127.0.0.1", 0, WithLogOutput(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+
+	if err := s.Start(); err != util.ServerStopped {
+		t.Fatalf("Start after Stop returned %v, want ServerStopped", err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//RunUntilSignal 启动并阻塞，收到信号后调用Shutdown优雅关闭，grace为等待消息处理完毕的最长时间，<= 0 表示一直等待
+//未指定信号时监听SIGINT和SIGTERM，Start出错时直接返回错误
+func (s *Server) RunUntilSignal(grace time.Duration, signals ...os.Signal) error {
+
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start()
+	}()
+
+	select {
+	case err := <-started:
+		return err
+	case sig := <-ch:
+		s.options.Logger.Infof("received signal %v, shutting down", sig)
+	}
+
+	ctx := context.Background()
+	if grace > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, grace)
+		defer cancel()
+	}
+
+	err := s.Shutdown(ctx)
+
+	// 等待acceptor退出
+	<-started
+	return err
+}
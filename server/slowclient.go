@@ -0,0 +1,66 @@
+package server
+
+import (
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//SlowClientCheck 检测写入积压持续超过阈值的连接
+func (c *ConnectManager) SlowClientCheck() {
+
+	threshold, duration, callback := c.options.SlowClientBacklog, c.options.SlowClientDuration, c.options.OnSlowClient
+	if threshold <= 0 || duration <= 0 || callback == nil {
+		return
+	}
+
+	// 检测的间隔为持续时间的一半，误差不会超过这个间隔
+	ticker := time.NewTicker(duration / 2)
+	defer ticker.Stop()
+
+	// connID => 开始积压的时间，已回调过的连接为零值
+	since := make(map[uint64]time.Time)
+	for {
+		select {
+		case <-c.options.ctx.Done():
+			return
+		case now := <-ticker.C:
+			alive := make(map[uint64]time.Time, len(since))
+			for _, connect := range c.GetConnects() {
+				backlog := connect.WriteBacklog()
+				if backlog <= threshold {
+					continue
+				}
+
+				// 第一次超过阈值，开始计时
+				id := connect.GetID()
+				start, ok := since[id]
+				if !ok {
+					alive[id] = now
+					continue
+				}
+
+				// 已经回调过，或者持续的时间还不够
+				if start.IsZero() || now.Sub(start) < duration {
+					alive[id] = start
+					continue
+				}
+				alive[id] = time.Time{}
+				c.slowClient(callback, connect, backlog)
+			}
+
+			// 积压已恢复、已关闭的连接不再记录
+			since = alive
+		}
+	}
+}
+
+//slowClient 执行回调，panic不能影响检测的协程
+func (c *ConnectManager) slowClient(callback iface.SlowClientFunc, connect iface.IConnect, backlog int) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.options.Logger.Errorf("connID[%d] OnSlowClient panic: %v", connect.GetID(), recovered)
+		}
+	}()
+	callback(connect, backlog)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
+)
+
+//joinHostPort 拼接监听地址，兼容IPv6，如：[::1]、::1、::
+func joinHostPort(ip string, port int) string {
+	return net.JoinHostPort(strings.Trim(ip, "[]"), strconv.Itoa(port))
+}
+
+//listenerAddr 通过getsockname获取监听的地址，获取失败时返回nil
+func listenerAddr(fd int) net.Addr {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return nil
+	}
+	return util.SockaddrToTCPOrUnixAddr(sa)
+}
+
+//resolveListenAddr 解析监听地址，返回socket的协议族和需要绑定的地址，network为tcp或udp
+func resolveListenAddr(network, address string, dualStack bool) (int, unix.Sockaddr, error) {
+
+	var (
+		ip   net.IP
+		port int
+		zone string
+	)
+
+	if network == "udp" {
+		addr, err := net.ResolveUDPAddr(network, address)
+		if err != nil {
+			return 0, nil, err
+		}
+		ip, port, zone = addr.IP, addr.Port, addr.Zone
+	} else {
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return 0, nil, err
+		}
+		ip, port, zone = addr.IP, addr.Port, addr.Zone
+	}
+
+	// 监听所有网卡且开启双栈，通过IPv6的[::]同时接收IPv4和IPv6
+	if (ip == nil || ip.IsUnspecified()) && dualStack {
+		return unix.AF_INET6, &unix.SockaddrInet6{Port: port}, nil
+	}
+
+	// IPv4
+	if ip == nil || ip.To4() != nil {
+		sa := &unix.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip.To4())
+		return unix.AF_INET, sa, nil
+	}
+
+	// IPv6
+	sa := &unix.SockaddrInet6{Port: port}
+	copy(sa.Addr[:], ip.To16())
+	if zone != "" {
+		if ifi, err := net.InterfaceByName(zone); err == nil {
+			sa.ZoneId = uint32(ifi.Index)
+		} else if id, err := strconv.Atoi(zone); err == nil {
+			sa.ZoneId = uint32(id)
+		}
+	}
+	return unix.AF_INET6, sa, nil
+}
+
+//setIPv6Only IPv6的socket是否只接收IPv6，未开启双栈时不接收IPv4
+func setIPv6Only(fd, domain int, dualStack bool) error {
+	if domain != unix.AF_INET6 {
+		return nil
+	}
+
+	v6only := 1
+	if dualStack {
+		v6only = 0
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, v6only)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+//skipWithoutIPv6 测试环境不支持IPv6时跳过
+func skipWithoutIPv6(t *testing.T) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	_ = ln.Close()
+}
+
+//assertEcho 通过conn发送一个数据包，并收到原样返回的数据
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	if _, err := conn.Write(packFrame(t, 1, []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	message, err := readFrame(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message.Bytes()) != "hello" {
+		t.Fatalf("echo got %q", message.Bytes())
+	}
+}
+
+func TestJoinHostPort(t *testing.T) {
+	cases := map[string]string{
+		"127.0.0.1": "127.0.0.1:80",
+		"::1":       "[::1]:80",
+		"[::1]":     "[::1]:80",
+		"::":        "[::]:80",
+		"[::]":      "[::]:80",
+		"":          ":80",
+	}
+	for ip, want := range cases {
+		if got := joinHostPort(ip, 80); got != want {
+			t.Errorf("joinHostPort(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestResolveListenAddr(t *testing.T) {
+	cases := []struct {
+		address   string
+		dualStack bool
+		domain    int
+		ip        net.IP
+	}{
+		{"127.0.0.1:80", false, unix.AF_INET, net.IPv4(127, 0, 0, 1)},
+		{"0.0.0.0:80", false, unix.AF_INET, net.IPv4zero},
+		{":80", false, unix.AF_INET, net.IPv4zero},
+		{"[::1]:80", false, unix.AF_INET6, net.IPv6loopback},
+		{"[::]:80", false, unix.AF_INET6, net.IPv6unspecified},
+		{"[::]:80", true, unix.AF_INET6, net.IPv6unspecified},
+		{"0.0.0.0:80", true, unix.AF_INET6, net.IPv6unspecified},
+		{":80", true, unix.AF_INET6, net.IPv6unspecified},
+	}
+
+	for _, c := range cases {
+		domain, sa, err := resolveListenAddr("tcp", c.address, c.dualStack)
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		if domain != c.domain {
+			t.Errorf("%s dualStack=%v: domain %d, want %d", c.address, c.dualStack, domain, c.domain)
+		}
+
+		var ip net.IP
+		switch addr := sa.(type) {
+		case *unix.SockaddrInet4:
+			ip = net.IP(addr.Addr[:])
+		case *unix.SockaddrInet6:
+			ip = net.IP(addr.Addr[:])
+		}
+		if !ip.Equal(c.ip) {
+			t.Errorf("%s dualStack=%v: ip %v, want %v", c.address, c.dualStack, ip, c.ip)
+		}
+	}
+
+	if _, _, err := resolveListenAddr("tcp", "[::1", false); err == nil {
+		t.Error("unterminated bracket should fail")
+	}
+}
+
+func TestIPv6Loopback(t *testing.T) {
+	skipWithoutIPv6(t)
+
+	for _, ip := range []string{"::1", "[::1]"} {
+		s := startServerOn(t, ip, 0)
+		s.AddRouter(1, new(echoRouter))
+
+		addr, ok := s.Addr().(*net.TCPAddr)
+		if !ok || !addr.IP.Equal(net.IPv6loopback) {
+			t.Fatalf("listen on %q got address %v", ip, s.Addr())
+		}
+		assertEcho(t, dialAddr(t, s.Addr().String()))
+	}
+}
+
+func TestIPv6Unspecified(t *testing.T) {
+	skipWithoutIPv6(t)
+
+	// 未开启双栈时只接收IPv6
+	s := startServerOn(t, "::", 0)
+	s.AddRouter(1, new(echoRouter))
+	port := strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+
+	assertEcho(t, dialAddr(t, net.JoinHostPort("::1", port)))
+	if conn, err := net.DialTimeout("tcp4", net.JoinHostPort("127.0.0.1", port), time.Second); err == nil {
+		_ = conn.Close()
+		t.Fatal("IPv4 connection accepted by an IPv6-only listener")
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	skipWithoutIPv6(t)
+
+	for _, ip := range []string{"::", "[::]", "0.0.0.0"} {
+		s := startServerOn(t, ip, 0, WithDualStack(true))
+		s.AddRouter(1, new(echoRouter))
+		port := strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+
+		assertEcho(t, dialAddr(t, net.JoinHostPort("127.0.0.1", port)))
+		assertEcho(t, dialAddr(t, net.JoinHostPort("::1", port)))
+	}
+}
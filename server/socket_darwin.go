@@ -3,61 +3,95 @@
 package server
 
 import (
-	"log"
 	"net"
 	"time"
 
-	"github.com/ikilobyte/netman/util"
-
 	"golang.org/x/sys/unix"
 )
 
 type socket struct {
 	fd       int
 	socketId int
+	path     string   // unix domain socket的文件路径
+	addr     net.Addr // 绑定后的地址，端口为0时是系统分配的端口
 }
 
 //newSocket 使用系统调用创建socket，不使用net包，net包未暴露fd的相关接口，只能通过反射获取，效率不高
-func createSocket(address string, duration time.Duration) *socket {
+func createSocket(address string, options *Options) (*socket, error) {
+
+	// 解析地址，IPv4或IPv6
+	domain, sa, err := resolveListenAddr("tcp", address, options.DualStack)
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建
-	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM, unix.IPPROTO_TCP)
 	if err != nil {
-		log.Panicln(err)
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+
+	// 非阻塞，其他进程（SO_REUSEPORT、Fork）抢先接收了连接时accept返回EAGAIN，不会卡住accept循环
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
 	}
 
 	// 设置属性
-	if secs := int(duration / time.Second); secs >= 1 {
+	if secs := int(options.TCPKeepAlive / time.Second); secs >= 1 {
 		if err := setKeepAlive(fd, secs); err != nil {
-			log.Panicln(err)
+			_ = unix.Close(fd)
+			return nil, err
 		}
 	}
 
 	// 复用TIME_WAIT状态的端口
 	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
-		log.Panicln(err)
+		_ = unix.Close(fd)
+		return nil, err
 	}
 
-	// 绑定
-	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
-	if err != nil {
-		log.Panicln(err)
+	// 多个进程监听同一个端口，由内核分配新连接
+	if options.ReusePort {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			_ = unix.Close(fd)
+			return nil, err
+		}
+	}
+
+	// 双栈
+	if err := setIPv6Only(fd, domain, options.DualStack); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
 	}
 
 	// 绑定端口
-	if err := unix.Bind(fd, &unix.SockaddrInet4{Port: tcpAddr.Port}); err != nil {
-		log.Panicln(err)
+	if err := unix.Bind(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
 	}
 
 	// 监听端口
-	if err := unix.Listen(fd, util.MaxListenerBacklog()); err != nil {
-		log.Panicln(err)
+	if err := unix.Listen(fd, options.ListenBacklog); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
 	}
 
 	return &socket{
 		fd:       fd,
 		socketId: -1,
+		addr:     listenerAddr(fd),
+	}, nil
+}
+
+//setNoDelay 设置TCP_NODELAY，开启后禁用Nagle算法，小包会立即发送
+func setNoDelay(fd int, noDelay bool) error {
+	value := 0
+	if noDelay {
+		value = 1
 	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, value)
 }
 
 //setKeepAlive 设置tcp属性
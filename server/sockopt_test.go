@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//acceptedSockopt 建立一个连接，返回服务端accept到的fd上的选项值
+func acceptedSockopt(t *testing.T, level, opt int, opts ...Option) int {
+	t.Helper()
+
+	type result struct {
+		value int
+		err   error
+	}
+	ch := make(chan result, 1)
+	opts = append(opts, WithOnConnect(func(connect iface.IConnect) {
+		value, err := unix.GetsockoptInt(connect.GetFd(), level, opt)
+		ch <- result{value, err}
+	}))
+	s := startServer(t, opts...)
+	dial(t, s)
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return r.value
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called")
+	}
+	return 0
+}
+
+func TestTCPNoDelay(t *testing.T) {
+	if v := acceptedSockopt(t, unix.IPPROTO_TCP, unix.TCP_NODELAY); v == 0 {
+		t.Fatal("TCP_NODELAY not set by default")
+	}
+	if v := acceptedSockopt(t, unix.IPPROTO_TCP, unix.TCP_NODELAY, WithTCPNoDelay(true)); v == 0 {
+		t.Fatal("TCP_NODELAY not set with WithTCPNoDelay(true)")
+	}
+	if v := acceptedSockopt(t, unix.IPPROTO_TCP, unix.TCP_NODELAY, WithTCPNoDelay(false)); v != 0 {
+		t.Fatal("TCP_NODELAY set with WithTCPNoDelay(false)")
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	first := startServer(t, WithReusePort(true))
+	first.AddRouter(1, new(echoRouter))
+	port := first.Addr().(*net.TCPAddr).Port
+
+	// 开启后第二个监听可以绑定同一个端口
+	second := startServerOn(t, "127.0.0.1", port, WithReusePort(true))
+	second.AddRouter(1, new(echoRouter))
+	if got := second.Addr().(*net.TCPAddr).Port; got != port {
+		t.Fatalf("second listener bound port %d, want %d", got, port)
+	}
+	for i := 0; i < 10; i++ {
+		assertEcho(t, dial(t, second))
+	}
+
+	// 未开启时绑定失败
+	s, err := NewWithError("127.0.0.1", port, WithLogOutput(io.Discard))
+	if err == nil {
+		s.Stop()
+		t.Fatal("binding the same port without SO_REUSEPORT succeeded")
+	}
+	if !errors.Is(err, unix.EADDRINUSE) {
+		t.Fatalf("bind without SO_REUSEPORT got %v, want EADDRINUSE", err)
+	}
+}
+
+func TestSocketBuffer(t *testing.T) {
+	const (
+		recv = 8192
+		send = 4096
+	)
+
+	// linux返回的是设置值的2倍（包含内核的额外开销）
+	inRange := func(value, size int) bool {
+		return value >= size && value <= size*2
+	}
+
+	if v := acceptedSockopt(t, unix.SOL_SOCKET, unix.SO_RCVBUF, WithSocketBuffer(recv, send)); !inRange(v, recv) {
+		t.Fatalf("SO_RCVBUF is %d, want %d", v, recv)
+	}
+	if v := acceptedSockopt(t, unix.SOL_SOCKET, unix.SO_SNDBUF, WithSocketBuffer(recv, send)); !inRange(v, send) {
+		t.Fatalf("SO_SNDBUF is %d, want %d", v, send)
+	}
+
+	// 0表示使用系统默认值
+	if v := acceptedSockopt(t, unix.SOL_SOCKET, unix.SO_RCVBUF); inRange(v, recv) {
+		t.Fatalf("SO_RCVBUF is %d without WithSocketBuffer", v)
+	}
+}
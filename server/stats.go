@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/ikilobyte/netman/eventloop"
+)
+
+//Stats 服务运行状态快照
+type Stats struct {
+	Connections   int    // 当前连接数量
+	TotalAccepted uint64 // 累计接受的连接数量
+	TotalClosed   uint64 // 累计关闭的连接数量
+	TotalMessages uint64 // 累计处理完毕的消息数量
+	BytesRead     uint64 // 累计读取的字节数
+	BytesWritten  uint64 // 累计写入的字节数
+	QueueDepth    int    // 等待分发的消息数量
+}
+
+//counters 运行时计数器，全部通过原子操作读写
+type counters struct {
+	accepted     uint64
+	closed       uint64
+	messages     uint64
+	bytesRead    uint64
+	bytesWritten uint64
+}
+
+//addRead .
+func (c *counters) addRead(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.bytesRead, uint64(n))
+	}
+}
+
+//addWritten .
+func (c *counters) addWritten(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.bytesWritten, uint64(n))
+	}
+}
+
+//Stats 获取服务运行状态
+func (s *Server) Stats() Stats {
+	counter := s.options.counters
+	return Stats{
+		Connections:   s.connectMgr.Len(),
+		TotalAccepted: atomic.LoadUint64(&counter.accepted),
+		TotalClosed:   atomic.LoadUint64(&counter.closed),
+		TotalMessages: atomic.LoadUint64(&counter.messages),
+		BytesRead:     atomic.LoadUint64(&counter.bytesRead),
+		BytesWritten:  atomic.LoadUint64(&counter.bytesWritten),
+		QueueDepth:    len(s.emitCh),
+	}
+}
+
+//EventLoopStats 获取每个事件循环管理的连接数量，可以用来判断连接分配是否均匀
+func (s *Server) EventLoopStats() []eventloop.LoopStat {
+	if loop, ok := s.eventloop.(*eventloop.EventLoop); ok {
+		return loop.Stats()
+	}
+	return nil
+}
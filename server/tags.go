@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/ikilobyte/netman/iface"
+)
+
+//AddTag 给连接打标签，如：region=eu、role=admin，同一个key只保留最后一次设置的value，连接关闭后自动清除
+func (c *BaseConnect) AddTag(key, value string) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	if c.tags == nil {
+		c.tags = make(map[string]string)
+	}
+	c.tags[key] = value
+}
+
+//RemoveTag 删除标签
+func (c *BaseConnect) RemoveTag(key string) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	delete(c.tags, key)
+}
+
+//GetTag 获取标签的值
+func (c *BaseConnect) GetTag(key string) (string, bool) {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+	value, ok := c.tags[key]
+	return value, ok
+}
+
+//HasTag 是否有这个标签，并且值相同
+func (c *BaseConnect) HasTag(key, value string) bool {
+	tag, ok := c.GetTag(key)
+	return ok && tag == value
+}
+
+//clearTags 连接关闭时清除所有标签
+func (c *BaseConnect) clearTags() {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	c.tags = nil
+}
+
+//FindConnections 查找满足条件的连接，如：按标签、属性查找
+func (s *Server) FindConnections(matcher func(conn iface.IConnect) bool) []iface.IConnect {
+	connects := make([]iface.IConnect, 0)
+	s.connectMgr.Range(func(conn iface.IConnect) bool {
+		if matcher(conn) {
+			connects = append(connects, conn)
+		}
+		return true
+	})
+	return connects
+}
+
+//BroadcastToTag 给有某个标签的连接推送消息，只会封包一次，仅路由模式可用
+func (s *Server) BroadcastToTag(key, value string, msgID uint32, data []byte) error {
+	return s.BroadcastFilter(msgID, data, func(conn iface.IConnect) bool {
+		return conn.HasTag(key, value)
+	})
+}
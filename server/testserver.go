@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ikilobyte/netman/common"
+	"golang.org/x/sys/unix"
+)
+
+//TestServer 不监听端口的Server，用于单元测试路由、中间件
+//Dial通过socketpair创建连接，不是net.Pipe，连接仍然交给真实的epoll/kqueue事件循环处理，和真实的连接一样经过封包解包、中间件、路由，不依赖网络和端口
+type TestServer struct {
+	*Server
+	startOnce sync.Once
+	lock      sync.Mutex // 和accept循环一样串行处理新连接
+}
+
+//NewTest 创建TestServer，只支持路由模式，不需要调用Start，使用完毕后调用Stop
+func NewTest(opts ...Option) (*TestServer, error) {
+
+	// 应用层协议模式，createServer中就会启动事件循环，需要在这之前设置
+	opts = append([]Option{func(options *Options) {
+		options.Application = common.RouterMode
+	}}, opts...)
+
+	server, _, err := createServer("", 0, func(options *Options) (*socket, error) {
+		return &socket{fd: -1, socketId: -1}, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TestServer{Server: server}, nil
+}
+
+//Dial 创建一个连接到TestServer的客户端，opts为客户端的可选项，封包方式需要和服务端一致
+//开启了自动重连时，每次重连都会创建新的socketpair
+func (s *TestServer) Dial(opts ...Option) (*Client, error) {
+
+	client, err := NewClient("socketpair", opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.dial = s.pair
+
+	if err := client.Connect(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+//Stop 停止，TestServer没有运行accept循环，需要在这里关闭acceptor
+func (s *TestServer) Stop() {
+	s.Server.Stop()
+	s.acceptor.Close()
+}
+
+//pair 创建一对互相连接的socket，一端作为服务端的连接，另一端返回给客户端
+func (s *TestServer) pair() (int, unix.Sockaddr, error) {
+
+	// 处理路由分组的数据，和Start一致
+	s.startOnce.Do(func() {
+		atomic.CompareAndSwapInt32(&s.status, stopped, started)
+		_ = s.routerMgr.ResolveGroup()
+	})
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	unix.CloseOnExec(fds[0])
+	unix.CloseOnExec(fds[1])
+
+	if err := unix.SetNonblock(fds[1], true); err != nil {
+		_ = unix.Close(fds[0])
+		_ = unix.Close(fds[1])
+		return -1, nil, err
+	}
+
+	// 和accept到的新连接一样处理
+	s.lock.Lock()
+	s.acceptor.(*acceptor).handle(fds[0], &unix.SockaddrUnix{Name: "@netman-test-client"}, s.eventloop)
+	s.lock.Unlock()
+
+	return fds[1], &unix.SockaddrUnix{Name: "@netman-test-server"}, nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+)
+
+//replyRouter 客户端收到的回复
+type replyRouter chan []byte
+
+func (r replyRouter) Do(request iface.IRequest) {
+	r <- append([]byte(nil), request.GetMessage().Bytes()...)
+}
+
+func TestNewTestDial(t *testing.T) {
+	s, err := NewTest(WithLogOutput(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	s.AddRouter(1, new(echoRouter))
+
+	if s.options.Application != common.RouterMode {
+		t.Fatalf("application mode %v, want RouterMode", s.options.Application)
+	}
+
+	client, err := s.Dial(WithLogOutput(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	replies := make(replyRouter, 1)
+	client.AddRouter(1, replies)
+	if _, err := client.Send(1, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case reply := <-replies:
+		if string(reply) != "hello" {
+			t.Fatalf("echo got %q", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply from TestServer")
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/tls"
+
+	"github.com/ikilobyte/netman/util"
+)
+
+//initTLSConfig 新连接使用的tls配置，优先使用WithTLSConfig，其次是WithTls加载的证书
+func initTLSConfig(options *Options) {
+	if !options.TlsEnable {
+		return
+	}
+
+	config := options.TlsConfig
+	if config == nil && options.TlsCertificate != nil {
+		config = &tls.Config{Certificates: []tls.Certificate{*options.TlsCertificate}}
+	}
+	if config != nil {
+		options.tlsConfig.Store(config)
+	}
+}
+
+//loadTLSConfig 获取当前的tls配置，未开启TLS时返回nil
+func (o *Options) loadTLSConfig() *tls.Config {
+	config, _ := o.tlsConfig.Load().(*tls.Config)
+	return config
+}
+
+//ReloadTLS 替换tls配置，如：证书续期后加载新的证书，之后的新连接在握手时使用新的配置，已有的连接不受影响
+//证书需要频繁更换时，也可以在tls.Config.GetCertificate中返回最新的证书，不需要调用ReloadTLS
+func (s *Server) ReloadTLS(config *tls.Config) error {
+	if !s.options.TlsEnable {
+		return util.TLSNotEnabled
+	}
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return util.TLSConfigInvalid
+	}
+	s.options.tlsConfig.Store(config)
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPartialDrain(t *testing.T) {
+	const frames = 200
+
+	for _, edgeTriggered := range []bool{false, true} {
+		for _, batch := range []bool{false, true} {
+			edgeTriggered, batch := edgeTriggered, batch
+			t.Run(fmt.Sprintf("edge=%v/batch=%v", edgeTriggered, batch), func(t *testing.T) {
+				router := new(countRouter)
+				s := startServer(t,
+					WithEpollEdgeTriggered(edgeTriggered),
+					WithBatchDispatch(batch),
+					WithReadBufferSize(64),
+				)
+				s.AddRouter(1, router)
+				s.AddRouter(2, new(echoRouter))
+
+				// 所有数据一次写入，之后不再有新的数据触发可读事件，每个包需要读取多次
+				var buff bytes.Buffer
+				for i := 0; i < frames; i++ {
+					buff.Write(packFrame(t, 1, bytes.Repeat([]byte("x"), 100)))
+				}
+				conn := dial(t, s)
+				if _, err := conn.Write(buff.Bytes()); err != nil {
+					t.Fatal(err)
+				}
+
+				// 剩余未读取的数据不能停留在socket中
+				waitFor(t, 2*time.Second, func() bool {
+					return atomic.LoadInt64(&router.count) == frames
+				})
+
+				// 连接仍然可用
+				if _, err := conn.Write(packFrame(t, 2, []byte("ping"))); err != nil {
+					t.Fatal(err)
+				}
+				message, err := readFrame(conn, time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(message.Bytes()) != "ping" {
+					t.Fatalf("echo got %q", message.Bytes())
+				}
+			})
+		}
+	}
+}
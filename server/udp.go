@@ -0,0 +1,522 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ikilobyte/netman/common"
+	"github.com/ikilobyte/netman/iface"
+	"github.com/ikilobyte/netman/util"
+	"golang.org/x/sys/unix"
+)
+
+//udpDefaultIdleTime UDP没有连接的概念，伪连接在这个时间内没有收到数据会被清除
+const udpDefaultIdleTime = time.Second * 60
+
+//UDPServer UDP服务，一个数据报就是一个完整的包，使用和TCP相同的路由和封包解包
+//UDP没有连接，也就没有OnConnect、OnClose以及Hooks回调
+type UDPServer struct {
+	ip         string
+	port       int
+	status     serverStatus       // 状态
+	options    *Options           // 可选项参数
+	fd         int                // UDP socket
+	packer     iface.IPacker      // 负责封包解包
+	routerMgr  *RouterMgr         // 路由统一管理
+	connectMgr *udpConnectManager // 伪连接管理，通过对端地址区分
+	wg         sync.WaitGroup     // 正在处理中的消息
+	cancel     context.CancelFunc // 取消服务的context
+	done       chan struct{}      // Start退出后关闭
+}
+
+//NewUDP 创建一个UDP服务，仅支持路由模式
+func NewUDP(ip string, port int, opts ...Option) *UDPServer {
+
+	options := parseOption(opts...)
+	options.Application = common.RouterMode
+	if err := prepareOption(options); err != nil {
+		log.Panicln(err)
+	}
+
+	// 伪连接的过期时间
+	if options.HeartbeatIdleTime <= 0 {
+		options.HeartbeatIdleTime = udpDefaultIdleTime
+	}
+	if options.HeartbeatCheckInterval <= 0 {
+		options.HeartbeatCheckInterval = options.HeartbeatIdleTime / 2
+	}
+
+	ctx, cancel := context.WithCancel(options.ctx)
+	options.ctx = ctx
+
+	fd := createUDPSocket(joinHostPort(ip, port), options.DualStack)
+	server := &UDPServer{
+		ip:         ip,
+		port:       port,
+		status:     stopped,
+		options:    options,
+		fd:         fd,
+		packer:     options.Packer,
+		routerMgr:  NewRouterMgr(),
+		connectMgr: newUDPConnectManager(fd, options),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	go server.connectMgr.HeartbeatCheck()
+
+	return server
+}
+
+//createUDPSocket 创建并绑定UDP socket，使用阻塞模式读取，通过读取超时检查服务状态
+func createUDPSocket(address string, dualStack bool) int {
+
+	domain, sa, err := resolveListenAddr("udp", address, dualStack)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	fd, err := unix.Socket(domain, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	if err != nil {
+		log.Panicln(err)
+	}
+	unix.CloseOnExec(fd)
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		log.Panicln(err)
+	}
+
+	// 读取超时后检查一次服务状态，Stop时不会一直阻塞
+	tv := unix.NsecToTimeval(int64(time.Second))
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := setIPv6Only(fd, domain, dualStack); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := unix.Bind(fd, sa); err != nil {
+		log.Panicln(err)
+	}
+
+	return fd
+}
+
+//AddRouter 添加路由
+func (s *UDPServer) AddRouter(msgID uint32, router iface.IRouter) {
+	s.routerMgr.Add(msgID, router)
+}
+
+//RemoveRouter 删除路由，启动后也可以调用
+func (s *UDPServer) RemoveRouter(msgID uint32) {
+	s.routerMgr.Remove(msgID)
+}
+
+//HasRouter 路由是否存在
+func (s *UDPServer) HasRouter(msgID uint32) bool {
+	return s.routerMgr.Has(msgID)
+}
+
+//AddResponseRouter 添加带响应对象的路由
+func (s *UDPServer) AddResponseRouter(msgID uint32, router iface.IResponseRouter) {
+	s.AddRouter(msgID, ResponseRouter(router))
+}
+
+//AddContextRouter 添加带context的路由，伪连接过期或服务关闭时ctx会被取消
+func (s *UDPServer) AddContextRouter(msgID uint32, router iface.IContextRouter) {
+	s.AddRouter(msgID, ContextRouter(router))
+}
+
+//Use 全局中间件
+func (s *UDPServer) Use(callable iface.MiddlewareFunc, more ...iface.MiddlewareFunc) *UDPServer {
+	s.routerMgr.globalMiddlewares = append(s.routerMgr.globalMiddlewares, callable)
+	s.routerMgr.globalMiddlewares = append(s.routerMgr.globalMiddlewares, more...)
+	return s
+}
+
+//Group 分组中间件
+func (s *UDPServer) Group(callable iface.MiddlewareFunc, more ...iface.MiddlewareFunc) iface.IMiddlewareGroup {
+	return s.routerMgr.NewGroup(callable, more...)
+}
+
+//Start 启动，会一直阻塞，直到Server停止或socket出现不可恢复的错误
+func (s *UDPServer) Start() error {
+	if !atomic.CompareAndSwapInt32(&s.status, stopped, started) {
+
+		// 已停止的Server不能再次启动，监听的socket已经关闭
+		if atomic.LoadInt32(&s.status) == stopping {
+			return util.ServerStopped
+		}
+		return nil
+	}
+	defer close(s.done)
+
+	if err := s.routerMgr.ResolveGroup(); err != nil {
+		return err
+	}
+
+	// 一个数据报最大为64KB
+	buffer := make([]byte, 65536)
+	for {
+		n, from, err := unix.Recvfrom(s.fd, buffer, 0)
+		if atomic.LoadInt32(&s.status) != started {
+			return nil
+		}
+
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+
+		s.handle(buffer[:n], from)
+	}
+}
+
+//handle 解析一个数据报，不完整的数据报直接丢弃
+func (s *UDPServer) handle(data []byte, from unix.Sockaddr) {
+
+	address := util.SockaddrToUDPAddr(from)
+	if address == nil {
+		return
+	}
+
+	// ip黑白名单
+	if s.options.ipFilter != nil && !s.options.ipFilter.Allow(address.(*net.UDPAddr).IP) {
+		return
+	}
+	s.options.counters.addRead(len(data))
+
+	headerLength := int(s.packer.GetHeaderLength())
+	if len(data) < headerLength {
+		return
+	}
+
+	message, err := s.packer.UnPack(data[:headerLength])
+	if err != nil {
+		s.options.Logger.Infof("udp unpack from %s error %v", address, err)
+		return
+	}
+
+	// 包体长度为0或者不完整
+	body := data[headerLength:]
+	if message.Len() <= 0 || len(body) < message.Len() {
+		return
+	}
+
+	// buffer会被复用，需要复制一份
+	bs := make([]byte, message.Len())
+	copy(bs, body)
+	message.SetData(bs)
+	if err := decodeBody(message); err != nil {
+		s.options.Logger.Infof("udp decode from %s error %v", address, err)
+		return
+	}
+
+	connect := s.connectMgr.getOrCreate(from, address)
+	atomic.AddUint64(&connect.bytesRead, uint64(len(data)))
+
+	// UDP可能会收到重复的数据报
+	if connect.isReplay(message) {
+		return
+	}
+	context := util.NewContext(util.NewRequest(connect, message, s.connectMgr))
+	if s.options.Heartbeat.isPong(context) {
+		return
+	}
+
+	s.wg.Add(1)
+	go func(ctx iface.IContext) {
+		defer s.wg.Done()
+		defer atomic.AddUint64(&s.options.counters.messages, 1)
+		s.routerMgr.Dispatch(ctx, s.options)
+	}(context)
+}
+
+//Stats 获取服务运行状态，Connections为当前的伪连接数量
+func (s *UDPServer) Stats() Stats {
+	counter := s.options.counters
+	return Stats{
+		Connections:   s.connectMgr.Len(),
+		TotalMessages: atomic.LoadUint64(&counter.messages),
+		BytesRead:     atomic.LoadUint64(&counter.bytesRead),
+		BytesWritten:  atomic.LoadUint64(&counter.bytesWritten),
+	}
+}
+
+//Stop 停止服务，等待处理中的消息完成后关闭socket
+func (s *UDPServer) Stop() {
+	status := atomic.SwapInt32(&s.status, stopping)
+	if status == stopping {
+		return
+	}
+	s.cancel()
+
+	// 等待读取循环退出，不再有新的消息
+	if status == started {
+		<-s.done
+	}
+	s.wg.Wait()
+	s.connectMgr.ClearAll()
+	_ = unix.Close(s.fd)
+}
+
+//udpConnect UDP伪连接，通过对端地址区分，发送数据时使用同一个socket
+type udpConnect struct {
+	*BaseConnect
+	remote     unix.Sockaddr
+	connectMgr *udpConnectManager
+}
+
+//newUDPConnect .
+func newUDPConnect(id uint64, fd int, remote unix.Sockaddr, address net.Addr, connectMgr *udpConnectManager) *udpConnect {
+
+	options := connectMgr.options
+	base := &BaseConnect{
+		id:         id,
+		fd:         fd,
+		packer:     options.Packer,
+		Address:    address,
+		writeQ:     util.NewQueue(),
+		state:      common.OnLine,
+		options:    options,
+		properties: make(map[string]interface{}),
+	}
+	base.ctx, base.cancel = context.WithCancel(options.ctx)
+	base.SetLastMessageTime(time.Now())
+	if options.Sequence && options.SeqDedupWindow > 0 {
+		base.seqWindow = util.NewSeqWindow(options.SeqDedupWindow)
+	}
+
+	if sa, err := unix.Getsockname(fd); err == nil {
+		base.localAddress = util.SockaddrToUDPAddr(sa)
+	}
+
+	return &udpConnect{
+		BaseConnect: base,
+		remote:      remote,
+		connectMgr:  connectMgr,
+	}
+}
+
+//Send 封包后发送一个数据报
+func (c *udpConnect) Send(msgID uint32, bs []byte) (int, error) {
+	if c.isDraining() {
+		return 0, util.ConnectClosing
+	}
+	return c.send(msgID, bs)
+}
+
+//send 封包后发送
+func (c *udpConnect) send(msgID uint32, bs []byte) (int, error) {
+	dataPack, err := c.pack(msgID, bs)
+	if err != nil {
+		return 0, err
+	}
+	return c.writePacket(dataPack)
+}
+
+//AsyncSend 异步发送
+func (c *udpConnect) AsyncSend(msgID uint32, bs []byte) error {
+	return c.asyncSend(c, msgID, bs)
+}
+
+//writePacket 发送已经封包好的数据
+func (c *udpConnect) writePacket(dataPack []byte) (int, error) {
+	if err := unix.Sendto(c.fd, dataPack, 0, c.remote); err != nil {
+		return 0, util.WrapSyscallError(util.OpWrite, c.id, c.fd, err)
+	}
+	c.addWritten(len(dataPack))
+	return len(dataPack), nil
+}
+
+//SendRaw 不经过封包，直接作为一个数据报发送
+func (c *udpConnect) SendRaw(data []byte) error {
+	if c.isDraining() {
+		return util.ConnectClosing
+	}
+	_, err := c.writePacket(data)
+	return err
+}
+
+//Read 数据报统一由UDPServer读取
+func (c *udpConnect) Read(bs []byte) (int, error) {
+	return 0, util.UDPNotSupported
+}
+
+//CloseWrite 伪连接共用同一个socket，不能关闭写端
+func (c *udpConnect) CloseWrite() error {
+	return util.UDPNotSupported
+}
+
+//PauseRead 伪连接共用同一个socket，不能单独暂停读取
+func (c *udpConnect) PauseRead() error {
+	return util.UDPNotSupported
+}
+
+//ResumeRead .
+func (c *udpConnect) ResumeRead() error {
+	return util.UDPNotSupported
+}
+
+//Close 删除伪连接，不会关闭socket
+func (c *udpConnect) Close() error {
+	c.connectMgr.Remove(c)
+	c.clearTags()
+	c.cancel()
+	return nil
+}
+
+//GetConnectMgr .
+func (c *udpConnect) GetConnectMgr() iface.IConnectManager {
+	return c.connectMgr
+}
+
+//udpConnectManager 伪连接管理，实现了iface.IConnectManager
+type udpConnectManager struct {
+	fd       int
+	connects map[string]*udpConnect // 对端地址 => 伪连接
+	ids      map[uint64]*udpConnect // connID => 伪连接
+	options  *Options
+	sync.RWMutex
+}
+
+//newUDPConnectManager .
+func newUDPConnectManager(fd int, options *Options) *udpConnectManager {
+	return &udpConnectManager{
+		fd:       fd,
+		connects: make(map[string]*udpConnect),
+		ids:      make(map[uint64]*udpConnect),
+		options:  options,
+	}
+}
+
+//getOrCreate 获取对端地址对应的伪连接，不存在时创建
+func (c *udpConnectManager) getOrCreate(remote unix.Sockaddr, address net.Addr) *udpConnect {
+	key := address.String()
+
+	c.RLock()
+	connect, ok := c.connects[key]
+	c.RUnlock()
+	if ok {
+		return connect
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if connect, ok = c.connects[key]; ok {
+		return connect
+	}
+
+	connect = newUDPConnect(c.options.nextConnID(), c.fd, remote, address, c)
+	c.connects[key] = connect
+	c.ids[connect.GetID()] = connect
+	return connect
+}
+
+//Get 伪连接没有自己的fd
+func (c *udpConnectManager) Get(connFD int) iface.IConnect {
+	return nil
+}
+
+//GetByID 通过连接ID获取伪连接
+func (c *udpConnectManager) GetByID(connID uint64) (iface.IConnect, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	connect, ok := c.ids[connID]
+	if !ok {
+		return nil, false
+	}
+	return connect, true
+}
+
+//Add 伪连接只能通过收到的数据报创建
+func (c *udpConnectManager) Add(conn iface.IConnect) int {
+	return c.Len()
+}
+
+//GetConnects 获取所有伪连接
+func (c *udpConnectManager) GetConnects() []iface.IConnect {
+	c.RLock()
+	defer c.RUnlock()
+	connects := make([]iface.IConnect, 0, len(c.connects))
+	for _, connect := range c.connects {
+		connects = append(connects, connect)
+	}
+	return connects
+}
+
+//Range 遍历所有伪连接，callable返回false时停止
+func (c *udpConnectManager) Range(callable func(conn iface.IConnect) bool) {
+	for _, connect := range c.GetConnects() {
+		if !callable(connect) {
+			return
+		}
+	}
+}
+
+//Remove 删除一个伪连接
+func (c *udpConnectManager) Remove(conn iface.IConnect) {
+	c.Lock()
+	defer c.Unlock()
+	key := conn.GetAddress().String()
+	if c.connects[key] == conn {
+		delete(c.connects, key)
+	}
+	delete(c.ids, conn.GetID())
+}
+
+//Len 伪连接数量
+func (c *udpConnectManager) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.connects)
+}
+
+//CountByIP 这个ip的伪连接数量
+func (c *udpConnectManager) CountByIP(ip string) int {
+	c.RLock()
+	defer c.RUnlock()
+	count := 0
+	for _, connect := range c.connects {
+		if util.AddrIP(connect.GetAddress()) == ip {
+			count += 1
+		}
+	}
+	return count
+}
+
+//ClearByEpFd UDP不使用epoll
+func (c *udpConnectManager) ClearByEpFd(epfd int) {}
+
+//ClearAll 清除所有伪连接
+func (c *udpConnectManager) ClearAll() {
+	for _, connect := range c.GetConnects() {
+		_ = closeWith(connect, common.CloseShutdown)
+	}
+}
+
+//HeartbeatCheck 清除过期的伪连接
+func (c *udpConnectManager) HeartbeatCheck() {
+	ticker := time.NewTicker(c.options.HeartbeatCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.options.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, connect := range c.GetConnects() {
+				if now.Sub(connect.GetLastMessageTime()) >= c.options.HeartbeatIdleTime {
+					_ = closeWith(connect, common.CloseIdleTimeout)
+				}
+			}
+		}
+	}
+}
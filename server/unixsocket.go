@@ -0,0 +1,45 @@
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+//createUnixSocket 创建unix domain socket并监听
+func createUnixSocket(path string, backlog int) (*socket, error) {
+
+	// 上次未正常退出时遗留的socket文件
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+
+	// 非阻塞，同createSocket
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	if err := unix.Listen(fd, backlog); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return &socket{
+		fd:       fd,
+		socketId: -1,
+		path:     path,
+		addr:     listenerAddr(fd),
+	}, nil
+}
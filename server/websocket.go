@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
+	"github.com/ikilobyte/netman/common"
 	"github.com/ikilobyte/netman/iface"
 	"github.com/ikilobyte/netman/util"
 	"io"
@@ -53,6 +54,7 @@ type websocketProtocol struct {
 	messageMode     uint8      // 消息类型
 	parseHeaderStep uint8      // 解析头数据到了第几个步骤
 	headerBytes     []byte
+	conn            iface.IConnect // 对外的连接实例，一般为自身，路由模式升级时为routerProtocol
 }
 
 //newWebsocketProtocol
@@ -74,6 +76,7 @@ func newWebsocketProtocol(baseConnect *BaseConnect) iface.IConnect {
 		parseHeaderStep: 0,
 		headerBytes:     []byte{},
 	}
+	c.conn = c
 
 	return c
 }
@@ -88,7 +91,9 @@ func (c *websocketProtocol) DecodePacket() (iface.IMessage, error) {
 		}
 		c.isHandleShake = true
 		// onopen
-		c.options.WebsocketHandler.Open(c)
+		if c.options.WebsocketHandler != nil {
+			c.options.WebsocketHandler.Open(c.conn)
+		}
 		return nil, nil
 	}
 
@@ -178,6 +183,8 @@ func (c *websocketProtocol) DecodePacket() (iface.IMessage, error) {
 			}
 		}
 
+		// 对端发送了close帧
+		c.SetCloseReason(common.ClosePeer)
 		_ = c.Close()
 		return nil, nil
 	case PING:
@@ -296,6 +303,11 @@ func (c *websocketProtocol) parsePayloadLength() error {
 		c.parseHeaderStep = parsePayloadLength
 	}
 
+	// 超过包体最大长度限制，避免分配过大的内存
+	if max := c.options.MaxBodyLength; max > 0 && c.fragmentLength > uint(max) {
+		return util.BodyLenExceedLimit
+	}
+
 	return nil
 }
 
@@ -322,7 +334,7 @@ func (c *websocketProtocol) handleShake() error {
 
 	// 读取数据异常
 	if err != nil {
-		util.Logger.Errorf("websocket handle shake err：%v", err)
+		c.options.Logger.Errorf("websocket handle shake err：%v", err)
 		return err
 	}
 
@@ -331,20 +343,20 @@ func (c *websocketProtocol) handleShake() error {
 	// 头部校验
 	headMatches := regexp.MustCompile(`GET /(.*?) HTTP/1.1`).FindStringSubmatch(sBuffer)
 	if len(headMatches) != 2 {
-		util.Logger.Errorf("websocket handle shake protocol err：%v", err)
+		c.options.Logger.Errorf("websocket handle shake protocol err：%v", err)
 		return io.EOF
 	}
 
 	// 边界校验
 	if strings.Index(sBuffer, "Connection: Upgrade") == -1 {
-		util.Logger.Errorf("websocket handle shake Upgrade err：%v", err)
+		c.options.Logger.Errorf("websocket handle shake Upgrade err：%v", err)
 		return io.EOF
 	}
 
 	// 校验是否有相关key
 	matches := regexp.MustCompile(`Sec-WebSocket-Key: (.+)`).FindStringSubmatch(sBuffer)
 	if len(matches) != 2 {
-		util.Logger.Errorf("websocket handle shake Sec-WebSocket-Key err：%v", err)
+		c.options.Logger.Errorf("websocket handle shake Sec-WebSocket-Key err：%v", err)
 		return io.EOF
 	}
 
@@ -374,6 +386,10 @@ func (c *websocketProtocol) handleShake() error {
 //Text 发送纯文本格式数据
 func (c *websocketProtocol) Text(bs []byte) (int, error) {
 
+	if c.isDraining() {
+		return 0, util.ConnectClosing
+	}
+
 	// 第一个字节
 	firstByte := uint8(1 | 128)
 	encode, err := c.encode(firstByte, bs)
@@ -386,6 +402,9 @@ func (c *websocketProtocol) Text(bs []byte) (int, error) {
 
 //Binary 发送二进制格式数据
 func (c *websocketProtocol) Binary(bs []byte) (int, error) {
+	if c.isDraining() {
+		return 0, util.ConnectClosing
+	}
 	firstByte := uint8(2 | 128)
 	encode, err := c.encode(firstByte, bs)
 	if err != nil {
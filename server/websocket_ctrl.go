@@ -71,46 +71,39 @@ func (c *websocketProtocol) encode(firstByte uint8, bs []byte) ([]byte, error) {
 
 //remove 从内存中移除
 func (c *websocketProtocol) remove() {
-	// 移除事件监听
-	_ = c.GetPoller().Remove(c.fd)
+	// 移除事件监听，从管理类中移除
+	c.detach(c.conn)
 
-	// 从管理类中移除
-	c.GetConnectMgr().Remove(c)
-
-	// 关闭成功才执行
-	if c.hooks != nil {
-		c.hooks.OnClose(c) // tcp onclose
-	}
+	// tcp onclose
+	c.runCloseHooks(c.conn)
 
 	// websocket onclose ，握手成功才执行Close回调
-	if c.isHandleShake {
-		c.options.WebsocketHandler.Close(c)
+	if c.isHandleShake && c.options.WebsocketHandler != nil {
+		c.options.WebsocketHandler.Close(c.conn)
 	}
 
-	// 重置状态
-	c.reset()
-	c.packetBuffer = nil
-	c.continueBuffer = nil
-	c.messageMode = 0
-
+	// 事件循环可能还在读取，不能重置packetBuffer等状态
 }
 
 //CloseCode 内部关闭，并指定相关code
 func (c *websocketProtocol) CloseCode(code uint16, reason string) error {
-	data := bytes.NewBuffer([]byte{})
-	if err := binary.Write(data, binary.BigEndian, code); err != nil {
-		return err
+
+	// 只有第一次关闭会执行
+	if !c.markClosed() {
+		return nil
 	}
 
+	data := bytes.NewBuffer([]byte{})
+	_ = binary.Write(data, binary.BigEndian, code)
+
 	// 写入reason
 	data.WriteString(reason)
 
 	firstByte := uint8(8 | 128)
 	encode, _ := c.encode(firstByte, data.Bytes())
 
-	if _, err := c.push(encode); err != nil {
-		return err
-	}
+	// 对端可能已经断开，close帧发送失败也需要关闭连接
+	_, _ = c.push(encode)
 
 	c.remove()
 
@@ -120,7 +113,7 @@ func (c *websocketProtocol) CloseCode(code uint16, reason string) error {
 //ping 发送ping包
 func (c *websocketProtocol) ping() {
 	_, _ = c.Write([]byte{137, 0})
-	util.Logger.Infof("websocket client fd[%d] id[%d] ping", c.fd, c.id)
+	c.options.Logger.Infof("websocket client fd[%d] id[%d] ping", c.fd, c.id)
 }
 
 //pong 发送pong包
@@ -0,0 +1,37 @@
+package util
+
+import "sync"
+
+//BufferPool 固定大小的[]byte复用池，减少读取数据时的内存分配
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+//NewBufferPool 创建一个复用池，size为每个buffer的长度
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{size: size}
+	p.pool.New = func() interface{} {
+		bs := make([]byte, size)
+		return &bs
+	}
+	return p
+}
+
+//Get 获取一个buffer，使用完毕后需要调用Put放回
+func (p *BufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+//Put 放回复用池，放回后不能再使用这个buffer
+func (p *BufferPool) Put(bs *[]byte) {
+	if bs == nil || len(*bs) != p.size {
+		return
+	}
+	p.pool.Put(bs)
+}
+
+//Size 每个buffer的长度
+func (p *BufferPool) Size() int {
+	return p.size
+}
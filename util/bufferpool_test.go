@@ -0,0 +1,74 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+var readSink []byte
+
+//readInto 模拟一次读事件：读取数据后复制到包体缓冲区
+func readInto(src *bytes.Reader, buff []byte, dst *bytes.Buffer) {
+	n, _ := src.Read(buff)
+	dst.Write(buff[:n])
+}
+
+func TestBufferPoolNoAlloc(t *testing.T) {
+	pool := NewBufferPool(4096)
+	pool.Put(pool.Get())
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		bs := pool.Get()
+		(*bs)[0] = 1
+		pool.Put(bs)
+	})
+	if allocs != 0 {
+		t.Fatalf("Get/Put allocated %.1f times per run", allocs)
+	}
+}
+
+func TestBufferPoolPutWrongSize(t *testing.T) {
+	pool := NewBufferPool(16)
+	small := make([]byte, 8)
+	pool.Put(&small)
+	pool.Put(nil)
+
+	if bs := pool.Get(); len(*bs) != 16 {
+		t.Fatalf("Get returned %d bytes, want 16", len(*bs))
+	}
+}
+
+//BenchmarkRead 每次读事件新分配buffer和使用复用池的对比
+func BenchmarkRead(b *testing.B) {
+	const size = 4096
+	data := bytes.Repeat([]byte("x"), size)
+
+	b.Run("alloc", func(b *testing.B) {
+		src := bytes.NewReader(data)
+		var dst bytes.Buffer
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			src.Reset(data)
+			dst.Reset()
+			buff := make([]byte, size)
+			readInto(src, buff, &dst)
+			readSink = buff
+		}
+	})
+
+	b.Run("pool", func(b *testing.B) {
+		pool := NewBufferPool(size)
+		src := bytes.NewReader(data)
+		var dst bytes.Buffer
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			src.Reset(data)
+			dst.Reset()
+			buff := pool.Get()
+			readInto(src, *buff, &dst)
+			pool.Put(buff)
+		}
+	})
+}
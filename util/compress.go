@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+//GzipCompressor gzip压缩，压缩率较高，CPU开销也较大，适合带宽敏感的场景
+type GzipCompressor struct {
+	level   int
+	writers sync.Pool // 复用gzip.Writer，避免每次压缩都分配内部缓冲
+}
+
+//NewGzipCompressor level取值同compress/gzip，如：gzip.BestSpeed
+func NewGzipCompressor(level int) *GzipCompressor {
+	return &GzipCompressor{level: level}
+}
+
+//Compress 压缩
+func (g *GzipCompressor) Compress(data []byte) ([]byte, error) {
+
+	var buff bytes.Buffer
+
+	writer, ok := g.writers.Get().(*gzip.Writer)
+	if ok {
+		writer.Reset(&buff)
+	} else {
+		var err error
+		if writer, err = gzip.NewWriterLevel(&buff, g.level); err != nil {
+			return nil, err
+		}
+	}
+	defer g.writers.Put(writer)
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+//Decompress 解压，最多解压出maxLength字节，超过时返回BodyLenExceedLimit，避免很小的数据解压出巨大的包体，0表示不限制
+func (g *GzipCompressor) Decompress(data []byte, maxLength int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if maxLength <= 0 {
+		return io.ReadAll(reader)
+	}
+
+	bs, err := io.ReadAll(io.LimitReader(reader, int64(maxLength)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) > maxLength {
+		return nil, BodyLenExceedLimit
+	}
+	return bs, nil
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//compressPayload 类似json的数据，有一定的重复
+func compressPayload(size int) []byte {
+	words := []string{`{"id":`, `"name":"netman",`, `"online":true,`, `"score":`, `"tags":["a","b"]},`}
+	r := rand.New(rand.NewSource(1))
+	var buff bytes.Buffer
+	for buff.Len() < size {
+		buff.WriteString(words[r.Intn(len(words))])
+		buff.WriteByte(byte('0' + r.Intn(10)))
+	}
+	return buff.Bytes()[:size]
+}
+
+func compressors() map[string]iface.ICompressor {
+	return map[string]iface.ICompressor{
+		"gzip":   NewGzipCompressor(gzip.BestSpeed),
+		"snappy": NewSnappyCompressor(),
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	inputs := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("abc"),
+		bytes.Repeat([]byte("a"), 100000),
+		random,
+		compressPayload(1 << 20),
+	}
+
+	for name, compressor := range compressors() {
+		for _, input := range inputs {
+			compressed, err := compressor.Compress(input)
+			if err != nil {
+				t.Fatalf("%s compress %d bytes: %v", name, len(input), err)
+			}
+			output, err := compressor.Decompress(compressed, len(input))
+			if err != nil {
+				t.Fatalf("%s decompress %d bytes: %v", name, len(input), err)
+			}
+			if !bytes.Equal(input, output) {
+				t.Fatalf("%s round trip of %d bytes mismatch", name, len(input))
+			}
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	bomb := make([]byte, 64<<20)
+
+	for name, compressor := range compressors() {
+		compressed, err := compressor.Compress(bomb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := compressor.Decompress(compressed, 1024); err != BodyLenExceedLimit {
+			t.Fatalf("%s expected BodyLenExceedLimit, got %v", name, err)
+		}
+		if _, err := compressor.Decompress(compressed, len(bomb)); err != nil {
+			t.Fatalf("%s decompress at the limit: %v", name, err)
+		}
+	}
+}
+
+func TestSnappyCorrupt(t *testing.T) {
+	compressor := NewSnappyCompressor()
+	compressed, _ := compressor.Compress(compressPayload(4096))
+
+	for i := 1; i < len(compressed); i++ {
+		if _, err := compressor.Decompress(compressed[:i], 0); err == nil {
+			t.Fatalf("truncated to %d bytes should fail", i)
+		}
+	}
+
+	// 偏移超过已解压的长度
+	if _, err := compressor.Decompress([]byte{0x08, 0x0e, 0x10, 0x00}, 0); err != SnappyCorrupt {
+		t.Fatalf("expected SnappyCorrupt, got %v", err)
+	}
+}
+
+func TestMessageDecodeLimit(t *testing.T) {
+	packer := NewDataPacker()
+	packer.SetMaxBodyLength(1024)
+	packer.SetCompression(NewGzipCompressor(gzip.BestSpeed), 0)
+
+	// 不经过Pack的长度检查，模拟对端发送很小的压缩数据
+	compressed, _ := NewGzipCompressor(gzip.BestSpeed).Compress(make([]byte, 1<<20))
+	message := &Message{Data: compressed, Compressed: true, compressor: packer.compressor, maxLength: 1024}
+	if err := message.Decode(); err != BodyLenExceedLimit {
+		t.Fatalf("expected BodyLenExceedLimit, got %v", err)
+	}
+}
+
+//BenchmarkCompress 对比CPU开销和压缩率，ratio为压缩后的长度/原始长度
+func BenchmarkCompress(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10} {
+		data := compressPayload(size)
+		for name, compressor := range compressors() {
+			b.Run(name+"/"+sizeName(size), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				var compressed []byte
+				for i := 0; i < b.N; i++ {
+					compressed, _ = compressor.Compress(data)
+				}
+				b.ReportMetric(float64(len(compressed))/float64(len(data)), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10} {
+		data := compressPayload(size)
+		for name, compressor := range compressors() {
+			compressed, _ := compressor.Compress(data)
+			b.Run(name+"/"+sizeName(size), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_, _ = compressor.Decompress(compressed, len(data))
+				}
+			})
+		}
+	}
+}
+
+func sizeName(size int) string {
+	return fmt.Sprintf("%dKB", size>>10)
+}
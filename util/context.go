@@ -6,9 +6,25 @@ import (
 	"github.com/ikilobyte/netman/iface"
 )
 
+//Batch 一次读取中解出的多个消息，开启批量投递时作为一个IContext投递到队列中，由一个协程按顺序处理
+//IContext的方法返回第一个消息的内容，队列已满丢弃、拒绝时整个Batch一起处理
+type Batch struct {
+	iface.IContext
+	Contexts []iface.IContext
+}
+
+//NewBatch .
+func NewBatch(contexts []iface.IContext) *Batch {
+	return &Batch{
+		IContext: contexts[0],
+		Contexts: contexts,
+	}
+}
+
 type Context struct {
-	storage *sync.Map
-	request iface.IRequest
+	storage  *sync.Map
+	request  iface.IRequest
+	response iface.IResponse
 }
 
 //NewContext .
@@ -32,6 +48,14 @@ func (c *Context) GetMessage() iface.IMessage {
 	return c.request.GetMessage()
 }
 
+//GetResponse 获取响应对象，中间件和路由使用的是同一个
+func (c *Context) GetResponse() iface.IResponse {
+	if c.response == nil {
+		c.response = NewResponse(c.request)
+	}
+	return c.response
+}
+
 func (c *Context) Set(key, value interface{}) {
 	c.storage.Store(key, value)
 }
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+//AESGCMCrypto AES-GCM加密，同时保证数据的完整性，密文格式：keyID(4字节)nonce(12字节)密文
+//密文中带有keyID，轮换密钥时先在接收端AddKey，再在发送端UseKey，新旧密钥的数据都可以正常解密
+type AESGCMCrypto struct {
+	keys    map[uint32]cipher.AEAD
+	current uint32 // 加密时使用的keyID
+	lock    sync.RWMutex
+}
+
+//NewAESGCMCrypto key的长度为16、24、32字节，分别对应AES-128、AES-192、AES-256
+func NewAESGCMCrypto(keyID uint32, key []byte) (*AESGCMCrypto, error) {
+	crypto := &AESGCMCrypto{keys: make(map[uint32]cipher.AEAD)}
+	if err := crypto.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	crypto.current = keyID
+	return crypto, nil
+}
+
+//AddKey 添加密钥，只用于解密，调用UseKey后才会用于加密
+func (a *AESGCMCrypto) AddKey(keyID uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.keys[keyID] = aead
+	return nil
+}
+
+//UseKey 切换加密时使用的密钥
+func (a *AESGCMCrypto) UseKey(keyID uint32) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.keys[keyID]; !ok {
+		return CryptoKeyNotFound
+	}
+	a.current = keyID
+	return nil
+}
+
+//RemoveKey 删除不再使用的密钥，不能删除正在使用的密钥
+func (a *AESGCMCrypto) RemoveKey(keyID uint32) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if keyID != a.current {
+		delete(a.keys, keyID)
+	}
+}
+
+//Encrypt 加密
+func (a *AESGCMCrypto) Encrypt(data []byte) ([]byte, error) {
+
+	a.lock.RLock()
+	keyID, aead := a.current, a.keys[a.current]
+	a.lock.RUnlock()
+
+	nonceSize := aead.NonceSize()
+	buff := make([]byte, 4+nonceSize, 4+nonceSize+len(data)+aead.Overhead())
+	binary.BigEndian.PutUint32(buff, keyID)
+
+	// nonce不能重复，每次随机生成
+	nonce := buff[4 : 4+nonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(buff, nonce, data, buff[:4]), nil
+}
+
+//Decrypt 解密
+func (a *AESGCMCrypto) Decrypt(data []byte) ([]byte, error) {
+
+	if len(data) < 4 {
+		return nil, DecryptFail
+	}
+	keyID := binary.BigEndian.Uint32(data)
+
+	a.lock.RLock()
+	aead, ok := a.keys[keyID]
+	a.lock.RUnlock()
+	if !ok {
+		return nil, CryptoKeyNotFound
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < 4+nonceSize+aead.Overhead() {
+		return nil, DecryptFail
+	}
+
+	plain, err := aead.Open(nil, data[4:4+nonceSize], data[4+nonceSize:], data[:4])
+	if err != nil {
+		return nil, DecryptFail
+	}
+	return plain, nil
+}
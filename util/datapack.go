@@ -1,8 +1,8 @@
 package util
 
 import (
-	"bytes"
 	"encoding/binary"
+	"log"
 
 	"github.com/ikilobyte/netman/iface"
 )
@@ -10,10 +10,41 @@ import (
 //DataPacker 可以自行实现IPacker，可以按照自己的协议格式来处理
 type DataPacker struct {
 	maxBodyLength uint32
+	lenBytes      int               // 长度字段占用的字节数，支持1、2、4
+	byteOrder     binary.ByteOrder  // 长度字段和msgID的字节序
+	includeMsgID  bool              // 头部是否包含msgID(4字节)，不包含时msgID始终为0
+	compressor    iface.ICompressor // 包体压缩，nil表示不压缩
+	compressMin   int               // 包体达到这个长度才压缩
+	crypto        iface.ICrypto     // 包体加密，nil表示不加密
+	includeSeq    bool              // 头部是否包含序列号(8字节)，在msgID之后
+	includeMagic  bool              // 头部最前面是否包含magic(2字节)和版本号(1字节)
+	magic         uint16            // 协议的magic，不一致时关闭连接
+	version       uint8             // 协议的版本号，不一致时关闭连接
 }
 
+//NewDataPacker 默认封包格式：data长度(4字节)msgID(4字节)data，小端字节序
 func NewDataPacker() *DataPacker {
-	return &DataPacker{}
+	return NewDataPackerWithOptions(4, binary.LittleEndian, true)
+}
+
+//NewDataPackerWithMagic 默认的封包格式，头部最前面加上magic(2字节)和版本号(1字节)，解包时magic或版本号不一致会关闭连接
+func NewDataPackerWithMagic(magic uint16, version uint8) *DataPacker {
+	packer := NewDataPacker()
+	packer.SetMagic(magic, version)
+	return packer
+}
+
+//NewDataPackerWithOptions 自定义长度字段的封包格式，用于对接已有的协议，如：2字节大端长度，不带msgID
+func NewDataPackerWithOptions(lenBytes int, byteOrder binary.ByteOrder, includeMsgID bool) *DataPacker {
+	if lenBytes != 1 && lenBytes != 2 && lenBytes != 4 {
+		log.Panicln("data packer lenBytes must be 1, 2 or 4")
+	}
+
+	return &DataPacker{
+		lenBytes:     lenBytes,
+		byteOrder:    byteOrder,
+		includeMsgID: includeMsgID,
+	}
 }
 
 //SetMaxBodyLength .
@@ -21,63 +52,209 @@ func (d *DataPacker) SetMaxBodyLength(maxBodyLength uint32) {
 	d.maxBodyLength = maxBodyLength
 }
 
-//Pack 封包格式：data长度(4字节)msgID(4字节)data
+//SetCompression 包体长度达到threshold时压缩，长度字段的最高位表示包体已压缩，收发双方都需要设置
+func (d *DataPacker) SetCompression(compressor iface.ICompressor, threshold int) {
+	d.compressor = compressor
+	d.compressMin = threshold
+}
+
+//SetCrypto 加密包体，在压缩之后加密，收发双方都需要设置
+func (d *DataPacker) SetCrypto(crypto iface.ICrypto) {
+	d.crypto = crypto
+}
+
+//SetSequence 头部是否包含序列号，收发双方都需要设置
+func (d *DataPacker) SetSequence(enabled bool) {
+	d.includeSeq = enabled
+}
+
+//SetMagic 头部最前面加上magic和版本号，可以拒绝使用其他协议的客户端(如：端口扫描)和旧版本的客户端，收发双方都需要设置
+func (d *DataPacker) SetMagic(magic uint16, version uint8) {
+	d.includeMagic = true
+	d.magic = magic
+	d.version = version
+}
+
+//Pack 封包格式：[magic(2字节)version(1字节)]data长度(lenBytes字节)[msgID(4字节)][seq(8字节)]data，包含序列号时seq为0
 func (d *DataPacker) Pack(msgID uint32, data []byte) ([]byte, error) {
+	return d.PackWithSeq(msgID, 0, data)
+}
 
-	buff := bytes.NewBuffer([]byte{})
+//PackWithSeq 封包时写入序列号，未调用SetSequence(true)时忽略seq
+func (d *DataPacker) PackWithSeq(msgID uint32, seq uint64, data []byte) ([]byte, error) {
+
+	// 超过包体最大长度限制
+	if d.maxBodyLength > 0 && uint64(len(data)) > uint64(d.maxBodyLength) {
+		return nil, BodyLenExceedLimit
+	}
+
+	// 压缩后没有变小时，发送原始数据
+	compressed := false
+	if d.compressor != nil && len(data) >= d.compressMin {
+		if bs, err := d.compressor.Compress(data); err == nil && len(bs) < len(data) {
+			data, compressed = bs, true
+		}
+	}
+
+	// 加密
+	if d.crypto != nil {
+		bs, err := d.crypto.Encrypt(data)
+		if err != nil {
+			return nil, err
+		}
+		data = bs
+	}
+
+	dataLen := uint64(len(data))
+
+	// 长度字段无法表示这么长的数据
+	if dataLen > d.maxLength() {
+		return nil, LengthFieldOverflow
+	}
+
+	// 标记为已压缩
+	if compressed {
+		dataLen |= d.compressFlag()
+	}
+
+	headerLength := int(d.GetHeaderLength())
+	buff := make([]byte, headerLength+len(data))
+
+	// 写入magic和版本号
+	offset := d.magicLength()
+	if d.includeMagic {
+		d.byteOrder.PutUint16(buff, d.magic)
+		buff[2] = d.version
+	}
 
 	// 写入data长度
-	if err := binary.Write(buff, binary.LittleEndian, uint32(len(data))); err != nil {
-		return nil, err
+	switch d.lenBytes {
+	case 1:
+		buff[offset] = uint8(dataLen)
+	case 2:
+		d.byteOrder.PutUint16(buff[offset:], uint16(dataLen))
+	default:
+		d.byteOrder.PutUint32(buff[offset:], uint32(dataLen))
 	}
 
 	// 写入msgID
-	if err := binary.Write(buff, binary.LittleEndian, msgID); err != nil {
-		return nil, err
+	if d.includeMsgID {
+		d.byteOrder.PutUint32(buff[offset+d.lenBytes:], msgID)
 	}
 
-	// 写入data
-	if err := binary.Write(buff, binary.LittleEndian, data); err != nil {
-		return nil, err
+	// 写入序列号
+	if d.includeSeq {
+		d.byteOrder.PutUint64(buff[headerLength-8:], seq)
 	}
 
-	return buff.Bytes(), nil
+	// 写入data
+	copy(buff[headerLength:], data)
+
+	return buff, nil
 }
 
-//UnPack 解包数据（传到这里的只有前8个字节），后续的data部分需要Read读取
+//UnPack 解包数据（传到这里的只有头部GetHeaderLength()个字节），后续的data部分需要Read读取
 func (d *DataPacker) UnPack(bs []byte) (iface.IMessage, error) {
 
-	dataBuff := bytes.NewBuffer(bs)
+	if len(bs) < int(d.GetHeaderLength()) {
+		return nil, HeadBytesLengthFail
+	}
+
 	var (
 		dataLen uint32
 		msgId   uint32
 	)
 
+	// 校验magic和版本号，不一致时不再读取之后的数据
+	offset := d.magicLength()
+	if d.includeMagic {
+		if d.byteOrder.Uint16(bs) != d.magic {
+			return nil, MagicMismatch
+		}
+		if bs[2] != d.version {
+			return nil, VersionMismatch
+		}
+	}
+
 	// 读取数据长度
-	if err := binary.Read(dataBuff, binary.LittleEndian, &dataLen); err != nil {
-		return nil, err
+	switch d.lenBytes {
+	case 1:
+		dataLen = uint32(bs[offset])
+	case 2:
+		dataLen = uint32(d.byteOrder.Uint16(bs[offset:]))
+	default:
+		dataLen = d.byteOrder.Uint32(bs[offset:])
+	}
+
+	// 长度字段的最高位表示包体已压缩
+	compressed := false
+	if d.compressor != nil && uint64(dataLen)&d.compressFlag() != 0 {
+		dataLen &^= uint32(d.compressFlag())
+		compressed = true
 	}
 
-	// 判断长度是否超过限制
-	if d.maxBodyLength > 0 && dataLen > d.maxBodyLength {
-		Logger.Errorln(BodyLenExceedLimit)
+	// 判断长度是否超过限制，加密后的包体会比原始数据长一些
+	maxBodyLength := d.maxBodyLength
+	if maxBodyLength > 0 && d.crypto != nil {
+		maxBodyLength += iface.CryptoMaxOverhead
+	}
+	if maxBodyLength > 0 && dataLen > maxBodyLength {
 		return nil, BodyLenExceedLimit
 	}
 
 	// 读取msgID
-	if err := binary.Read(dataBuff, binary.LittleEndian, &msgId); err != nil {
-		return nil, err
+	if d.includeMsgID {
+		msgId = d.byteOrder.Uint32(bs[offset+d.lenBytes:])
+	}
+
+	// 读取序列号
+	var seq uint64
+	if d.includeSeq {
+		seq = d.byteOrder.Uint64(bs[d.GetHeaderLength()-8:])
 	}
 
 	return &Message{
-		MsgID:   msgId,
-		DataLen: dataLen,
+		MsgID:      msgId,
+		DataLen:    dataLen,
+		Sequence:   seq,
+		Compressed: compressed,
+		compressor: d.compressor,
+		crypto:     d.crypto,
+		maxLength:  d.maxBodyLength,
 	}, nil
 }
 
 //GetHeaderLength 获取头部长度
 func (d *DataPacker) GetHeaderLength() uint32 {
-	return 8
+	length := uint32(d.magicLength() + d.lenBytes)
+	if d.includeMsgID {
+		length += 4
+	}
+	if d.includeSeq {
+		length += 8
+	}
+	return length
+}
+
+//magicLength magic和版本号占用的字节数
+func (d *DataPacker) magicLength() int {
+	if d.includeMagic {
+		return 3
+	}
+	return 0
+}
+
+//maxLength 长度字段能表示的最大长度，开启压缩后最高位用作压缩标记
+func (d *DataPacker) maxLength() uint64 {
+	if d.compressor != nil {
+		return d.compressFlag() - 1
+	}
+	return 1<<(uint(d.lenBytes)*8) - 1
+}
+
+//compressFlag 长度字段的最高位
+func (d *DataPacker) compressFlag() uint64 {
+	return 1 << (uint(d.lenBytes)*8 - 1)
 }
 
 //readData 读取数据
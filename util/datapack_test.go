@@ -0,0 +1,26 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestUnPackOversizedDoesNotLog(t *testing.T) {
+	var output bytes.Buffer
+	previous := Logger.Out
+	Logger.SetOutput(&output)
+	defer Logger.SetOutput(previous)
+
+	packer := NewDataPacker()
+	packer.SetMaxBodyLength(1024)
+
+	head := make([]byte, packer.GetHeaderLength())
+	binary.LittleEndian.PutUint32(head, 1<<30)
+	if _, err := packer.UnPack(head); err != BodyLenExceedLimit {
+		t.Fatalf("UnPack got %v, want BodyLenExceedLimit", err)
+	}
+	if output.Len() > 0 {
+		t.Fatalf("UnPack logged through the global logger: %s", output.String())
+	}
+}
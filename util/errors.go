@@ -1,6 +1,12 @@
 package util
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+)
 
 var HeadBytesLengthFail = errors.New("head bytes fail")
 var RouterNotFound = errors.New("router Not Found")
@@ -12,3 +18,110 @@ var WebsocketPingPayloadOversize = errors.New("websocket ping payload oversize")
 var WebsocketCtrlMessageMustNotFragmented = errors.New("websocket control message MUST NOT be fragmented")
 var WebsocketMustUtf8 = errors.New("websocket text message must utf-8")
 var WebsocketProtocolError = errors.New("websocket protocol error")
+var PropertyNotFound = errors.New("property not found")
+var ApplicationNotRouterMode = errors.New("application not router mode")
+var ConnectNotFound = errors.New("connect not found")
+var WriteTimeout = errors.New("write timeout")
+var LengthFieldOverflow = errors.New("data length overflow length field")
+var InvalidJSON = errors.New("data is not valid json")
+var PackerNotCodec = errors.New("packer not implements iface.ICodec")
+var NotProtoMessage = errors.New("value is not proto.Message")
+var UDPNotSupported = errors.New("operation not supported in udp mode")
+var WebsocketPacketIncomplete = errors.New("websocket payload is not a complete packet")
+var SendQueueFull = errors.New("send queue is full")
+var ConnectClosed = errors.New("connect closed")
+var ConnectWriteClosed = errors.New("connect write side closed")
+var DialTimeout = errors.New("dial timeout")
+var ClientClosed = errors.New("client closed")
+var ClientNotConnected = errors.New("client not connected")
+var ClientTLSNotSupported = errors.New("client does not support tls")
+var DecompressFail = errors.New("decompress body fail")
+var SnappyCorrupt = errors.New("snappy: corrupt input")
+var DecryptFail = errors.New("decrypt body fail")
+var CryptoKeyNotFound = errors.New("crypto key not found")
+var NotListenerFD = errors.New("fd is not a listening socket")
+var ConnectClosing = errors.New("connect is closing")
+var CloseTimeout = errors.New("close gracefully timeout")
+var ServerStopped = errors.New("server stopped")
+var PollerStopped = errors.New("poller stopped")
+var MagicMismatch = errors.New("packet magic mismatch")
+var VersionMismatch = errors.New("protocol version mismatch")
+var ProxyHeaderInvalid = errors.New("invalid proxy protocol header")
+var ProxyAddressRejected = errors.New("proxy protocol source address rejected")
+var ProxyHeaderTimeout = errors.New("proxy protocol header timeout")
+var TLSNotEnabled = errors.New("tls is not enabled")
+var TLSConfigInvalid = errors.New("tls config has no certificate")
+var Unauthenticated = errors.New("unauthenticated")
+
+//SyscallError 系统调用失败时带上操作、连接ID和fd，方便排查是哪个连接的什么操作出错
+//Unwrap返回原始的syscall.Errno，可以继续使用errors.Is(err, unix.EPIPE)判断
+type SyscallError struct {
+	Op     string // OpAccept、OpRead、OpWrite、OpSetsockopt
+	ConnID uint64 // 连接ID，accept等还没有连接时为0
+	Fd     int    // 出错的fd，accept时为listener的fd
+	Err    error  // 原始的错误
+}
+
+const (
+	OpAccept     = "accept"
+	OpRead       = "read"
+	OpWrite      = "write"
+	OpSetsockopt = "setsockopt"
+)
+
+func (s *SyscallError) Error() string {
+	if s.ConnID == 0 {
+		return fmt.Sprintf("%s fd[%d]: %v", s.Op, s.Fd, s.Err)
+	}
+	return fmt.Sprintf("%s connID[%d] fd[%d]: %v", s.Op, s.ConnID, s.Fd, s.Err)
+}
+
+//Unwrap .
+func (s *SyscallError) Unwrap() error {
+	return s.Err
+}
+
+//WrapSyscallError 只包装syscall.Errno，nil、框架自身的错误、已经包装过的错误原样返回
+func WrapSyscallError(op string, connID uint64, fd int, err error) error {
+	if _, ok := err.(syscall.Errno); !ok {
+		return err
+	}
+	return &SyscallError{Op: op, ConnID: connID, Fd: fd, Err: err}
+}
+
+//BindError IRequest.Bind失败时返回，Stage区分是解码失败还是校验失败
+type BindError struct {
+	Stage string // BindDecode、BindValidate
+	Err   error  // Unmarshal或Validate返回的错误
+}
+
+const (
+	BindDecode   = "decode"   // 解码包体失败
+	BindValidate = "validate" // Validate返回了错误
+)
+
+func (b *BindError) Error() string {
+	return fmt.Sprintf("bind %s failed: %v", b.Stage, b.Err)
+}
+
+//Unwrap 可以使用errors.Is、errors.As判断原始的错误
+func (b *BindError) Unwrap() error {
+	return b.Err
+}
+
+//BroadcastError 广播时发送失败的连接，key为连接ID
+type BroadcastError map[uint64]error
+
+func (b BroadcastError) Error() string {
+	ids := make([]uint64, 0, len(b))
+	for connID := range b {
+		ids = append(ids, connID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	items := make([]string, 0, len(ids))
+	for _, connID := range ids {
+		items = append(items, fmt.Sprintf("connID[%d] %v", connID, b[connID]))
+	}
+	return fmt.Sprintf("broadcast failed: %s", strings.Join(items, ", "))
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"net"
+	"strings"
+)
+
+//IPFilter ip白名单/黑名单
+type IPFilter struct {
+	allowed []*net.IPNet
+	blocked []*net.IPNet
+}
+
+//NewIPFilter 解析CIDR，也可以是单个ip
+func NewIPFilter(allowed, blocked []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+
+	var err error
+	if filter.allowed, err = parseCIDRs(allowed); err != nil {
+		return nil, err
+	}
+
+	if filter.blocked, err = parseCIDRs(blocked); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+//Allow 是否允许这个ip，配置了白名单时必须在白名单中，且不能在黑名单中
+func (f *IPFilter) Allow(ip net.IP) bool {
+	if len(f.allowed) > 0 && !containsIP(f.allowed, ip) {
+		return false
+	}
+	return !containsIP(f.blocked, ip)
+}
+
+func parseCIDRs(items []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+
+		// 单个ip
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil {
+				if ip.To4() != nil {
+					item += "/32"
+				} else {
+					item += "/128"
+				}
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"encoding/binary"
+	"encoding/json"
+)
+
+//JSONPacker 包体为JSON的封包方式，头部为：data长度(4字节)msgID(4字节)，大端字节序，方便浏览器等客户端调试
+type JSONPacker struct {
+	*DataPacker
+}
+
+//NewJSONPacker .
+func NewJSONPacker() *JSONPacker {
+	return &JSONPacker{
+		DataPacker: NewDataPackerWithOptions(4, binary.BigEndian, true),
+	}
+}
+
+//Pack data必须是合法的JSON
+func (j *JSONPacker) Pack(msgID uint32, data []byte) ([]byte, error) {
+	if len(data) > 0 && !json.Valid(data) {
+		return nil, InvalidJSON
+	}
+	return j.DataPacker.Pack(msgID, data)
+}
+
+//PackValue 将value编码为JSON后封包
+func (j *JSONPacker) PackValue(msgID uint32, value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return j.DataPacker.Pack(msgID, data)
+}
+
+//Marshal 实现iface.ICodec
+func (j *JSONPacker) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+//Unmarshal 实现iface.ICodec
+func (j *JSONPacker) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"github.com/ikilobyte/netman/iface"
+	"github.com/sirupsen/logrus"
+)
+
+//logrusLogger 使用logrus实现ILogger
+type logrusLogger struct {
+	*logrus.Entry
+}
+
+//NewLogrusLogger 将logrus包装为ILogger
+func NewLogrusLogger(logger *logrus.Logger) iface.ILogger {
+	return &logrusLogger{Entry: logrus.NewEntry(logger)}
+}
+
+//WithFields 附带字段
+func (l *logrusLogger) WithFields(fields map[string]interface{}) iface.ILogger {
+	return &logrusLogger{Entry: l.Entry.WithFields(fields)}
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+//sampledLogger 相同级别、相同格式的日志在interval内只输出第一条，之后的只计数，窗口结束时输出重复的次数
+type sampledLogger struct {
+	logger   iface.ILogger
+	interval time.Duration
+	sampler  *logSampler // WithFields派生的实例共用，附带字段不同的日志也会合并
+}
+
+//logSampler .
+type logSampler struct {
+	entries map[string]*sampleEntry
+	lock    sync.Mutex
+}
+
+//sampleEntry 一个窗口内某条日志的状态
+type sampleEntry struct {
+	started    time.Time     // 窗口开始的时间
+	suppressed int           // 窗口内被合并的数量
+	logger     iface.ILogger // 最后一次被合并的日志实例
+	args       []interface{} // 最后一次被合并的参数
+}
+
+//NewSampledLogger 对日志采样，interval内重复的日志会合并为一条"repeated N times"，interval <= 0 时直接返回logger
+func NewSampledLogger(logger iface.ILogger, interval time.Duration) iface.ILogger {
+	if interval <= 0 {
+		return logger
+	}
+	return &sampledLogger{
+		logger:   logger,
+		interval: interval,
+		sampler:  &logSampler{entries: make(map[string]*sampleEntry)},
+	}
+}
+
+func (l *sampledLogger) Debugf(format string, args ...interface{}) {
+	l.log(levelDebug, format, args)
+}
+
+func (l *sampledLogger) Infof(format string, args ...interface{}) {
+	l.log(levelInfo, format, args)
+}
+
+func (l *sampledLogger) Warnf(format string, args ...interface{}) {
+	l.log(levelWarn, format, args)
+}
+
+func (l *sampledLogger) Errorf(format string, args ...interface{}) {
+	l.log(levelError, format, args)
+}
+
+//WithFields 附带字段，和原实例共用采样状态
+func (l *sampledLogger) WithFields(fields map[string]interface{}) iface.ILogger {
+	return &sampledLogger{
+		logger:   l.logger.WithFields(fields),
+		interval: l.interval,
+		sampler:  l.sampler,
+	}
+}
+
+//log 窗口内第一次出现时直接输出，之后的只计数，第一次被合并时启动定时器，窗口结束后输出合并的数量
+func (l *sampledLogger) log(level, format string, args []interface{}) {
+
+	key := level + "|" + format
+	now := time.Now()
+
+	l.sampler.lock.Lock()
+	entry, ok := l.sampler.entries[key]
+	if !ok || now.Sub(entry.started) >= l.interval {
+		l.sampler.entries[key] = &sampleEntry{started: now}
+		l.sampler.lock.Unlock()
+
+		// 经过了一层包装，logrus记录的file是当前文件，通过caller字段记录实际调用的位置
+		logger := l.logger
+		if _, file, line, ok := runtime.Caller(2); ok {
+			logger = logger.WithFields(map[string]interface{}{"caller": file + ":" + strconv.Itoa(line)})
+		}
+		writeLog(logger, level, format, args...)
+		return
+	}
+
+	entry.suppressed += 1
+	entry.logger = l.logger
+	entry.args = args
+	if entry.suppressed == 1 {
+		time.AfterFunc(l.interval-now.Sub(entry.started), func() {
+			l.flush(key, level, format)
+		})
+	}
+	l.sampler.lock.Unlock()
+}
+
+//flush 窗口结束，输出被合并的数量，下一条相同的日志会开始新的窗口
+func (l *sampledLogger) flush(key, level, format string) {
+	l.sampler.lock.Lock()
+	entry, ok := l.sampler.entries[key]
+	if ok {
+		delete(l.sampler.entries, key)
+	}
+	l.sampler.lock.Unlock()
+
+	if !ok || entry.suppressed <= 0 {
+		return
+	}
+	writeLog(entry.logger, level, "%s (repeated %d times in %v)", fmt.Sprintf(format, entry.args...), entry.suppressed, l.interval)
+}
+
+//writeLog 按级别输出
+func writeLog(logger iface.ILogger, level, format string, args ...interface{}) {
+	switch level {
+	case levelDebug:
+		logger.Debugf(format, args...)
+	case levelInfo:
+		logger.Infof(format, args...)
+	case levelWarn:
+		logger.Warnf(format, args...)
+	default:
+		logger.Errorf(format, args...)
+	}
+}
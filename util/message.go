@@ -1,5 +1,7 @@
 package util
 
+import "github.com/ikilobyte/netman/iface"
+
 //Message 收到数据的封装
 type Message struct {
 	MsgID       uint32 // 消息ID
@@ -7,12 +9,22 @@ type Message struct {
 	Data        []byte // 消息
 	IsWebSocket bool   // 是否为websocket协议
 	Opcode      uint8  // 操作码
+	Sequence    uint64 // 序列号，头部不包含序列号时为0
+	Compressed  bool   // 包体是否已压缩，读取完包体后通过Decode解压
+	compressor  iface.ICompressor
+	crypto      iface.ICrypto // 不为nil时包体已加密
+	maxLength   uint32        // 解密、解压后的最大长度，0表示不限制
 }
 
 func (m *Message) ID() uint32 {
 	return m.MsgID
 }
 
+//Seq 序列号，0表示未设置
+func (m *Message) Seq() uint64 {
+	return m.Sequence
+}
+
 func (m *Message) String() string {
 	return string(m.Data)
 }
@@ -51,3 +63,37 @@ func (m *Message) IsText() bool {
 func (m *Message) IsBinary() bool {
 	return m.Opcode == 2
 }
+
+//Decode 读取完包体后先解密再解压，和封包时的顺序相反，处理后包体长度超过限制时返回BodyLenExceedLimit
+func (m *Message) Decode() error {
+
+	data := m.Data
+
+	if m.crypto != nil {
+		plain, err := m.crypto.Decrypt(data)
+		if err != nil {
+			return DecryptFail
+		}
+		data = plain
+		m.crypto = nil
+	}
+
+	if m.Compressed && m.compressor != nil {
+		inflated, err := m.compressor.Decompress(data, int(m.maxLength))
+		if err == BodyLenExceedLimit {
+			return err
+		}
+		if err != nil {
+			return DecompressFail
+		}
+		data = inflated
+		m.Compressed = false
+	}
+
+	if m.maxLength > 0 && len(data) > int(m.maxLength) {
+		return BodyLenExceedLimit
+	}
+
+	m.SetData(data)
+	return nil
+}
@@ -0,0 +1,45 @@
+package protopack
+
+import (
+	"github.com/ikilobyte/netman/util"
+	"google.golang.org/protobuf/proto"
+)
+
+//ProtoPacker 包体为protobuf的封包方式，头部和默认的封包方式一致：data长度(4字节)msgID(4字节)
+type ProtoPacker struct {
+	*util.DataPacker
+}
+
+//NewProtoPacker .
+func NewProtoPacker() *ProtoPacker {
+	return &ProtoPacker{
+		DataPacker: util.NewDataPacker(),
+	}
+}
+
+//PackMessage 将protobuf消息编码后封包
+func (p *ProtoPacker) PackMessage(msgID uint32, message proto.Message) ([]byte, error) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return p.Pack(msgID, data)
+}
+
+//Marshal 实现iface.ICodec，v必须是proto.Message
+func (p *ProtoPacker) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, util.NotProtoMessage
+	}
+	return proto.Marshal(message)
+}
+
+//Unmarshal 实现iface.ICodec，v必须是proto.Message
+func (p *ProtoPacker) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return util.NotProtoMessage
+	}
+	return proto.Unmarshal(data, message)
+}
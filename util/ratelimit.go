@@ -0,0 +1,47 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+//TokenBucket 令牌桶，按固定速率生成令牌，最多保存burst个
+type TokenBucket struct {
+	rate   float64 // 每秒生成的令牌数
+	burst  float64 // 桶的容量
+	tokens float64 // 当前剩余的令牌，可以为负数，表示已经预支的令牌
+	last   time.Time
+	lock   sync.Mutex
+}
+
+//NewTokenBucket 创建令牌桶，初始时是满的
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+//Take 取出n个令牌，令牌不足时会预支，返回需要等待多长时间才能还清预支的令牌
+func (b *TokenBucket) Take(n int) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -1,26 +1,39 @@
 package util
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/ikilobyte/netman/iface"
 )
 
+//requestID 自增的请求ID
+var requestID uint64
+
 type Request struct {
+	id         uint64
 	message    iface.IMessage
 	connect    iface.IConnect
 	connectMgr iface.IConnectManager
+	ctx        context.Context
 }
 
 func NewRequest(connect iface.IConnect, message iface.IMessage, connectMgr iface.IConnectManager) *Request {
 	connect.SetLastMessageTime(time.Now())
 	return &Request{
+		id:         atomic.AddUint64(&requestID, 1),
 		connect:    connect,
 		message:    message,
 		connectMgr: connectMgr,
 	}
 }
 
+//ID 请求ID，进程内唯一，用于串联中间件、路由以及日志
+func (r *Request) ID() uint64 {
+	return r.id
+}
+
 //GetConnect 获取连接
 func (r *Request) GetConnect() iface.IConnect {
 	return r.connect
@@ -35,3 +48,50 @@ func (r *Request) GetMessage() iface.IMessage {
 func (r *Request) GetConnects() []iface.IConnect {
 	return r.connectMgr.GetConnects()
 }
+
+//Peek 返回包体的副本，中间件可以按包体的内容做判断(如：按某个字段分发、探测子协议)，修改副本不会影响路由中GetMessage().Bytes()读取到的数据
+func (r *Request) Peek() []byte {
+	data := r.message.Bytes()
+	if data == nil {
+		return nil
+	}
+	peek := make([]byte, len(data))
+	copy(peek, data)
+	return peek
+}
+
+//Unmarshal 使用连接的Packer解码包体，Packer需要实现iface.ICodec，如：JSONPacker、protopack.ProtoPacker
+func (r *Request) Unmarshal(v interface{}) error {
+	codec, ok := r.connect.GetPacker().(iface.ICodec)
+	if !ok {
+		return PackerNotCodec
+	}
+	return codec.Unmarshal(r.message.Bytes(), v)
+}
+
+//Bind 解码包体，v实现了iface.IValidator时再调用Validate校验，失败时返回*BindError，中间件可以据此统一回复错误
+func (r *Request) Bind(v interface{}) error {
+	if err := r.Unmarshal(v); err != nil {
+		return &BindError{Stage: BindDecode, Err: err}
+	}
+
+	if validator, ok := v.(iface.IValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return &BindError{Stage: BindValidate, Err: err}
+		}
+	}
+	return nil
+}
+
+//Context 本次请求的context，未设置时为连接的context
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return r.connect.Context()
+}
+
+//SetContext 设置本次请求的context
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
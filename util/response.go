@@ -0,0 +1,64 @@
+package util
+
+import (
+	"encoding/json"
+
+	"github.com/ikilobyte/netman/iface"
+)
+
+//ErrorResponse SendError发送的包体
+type ErrorResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+type Response struct {
+	request iface.IRequest
+	filters []iface.ResponseFilter
+}
+
+//NewResponse .
+func NewResponse(request iface.IRequest) *Response {
+	return &Response{
+		request: request,
+		filters: make([]iface.ResponseFilter, 0),
+	}
+}
+
+//Send 经过所有Filter处理后发送
+func (r *Response) Send(msgID uint32, data []byte) error {
+
+	var err error
+	for _, filter := range r.filters {
+		if msgID, data, err = filter(msgID, data); err != nil {
+			return err
+		}
+	}
+
+	_, err = r.request.GetConnect().Send(msgID, data)
+	return err
+}
+
+//Reply 使用请求的msgID响应
+func (r *Response) Reply(data []byte) error {
+	return r.Send(r.request.GetMessage().ID(), data)
+}
+
+//SendError 使用请求的msgID响应错误信息
+func (r *Response) SendError(code int, msg string) error {
+	data, err := json.Marshal(&ErrorResponse{Code: code, Msg: msg})
+	if err != nil {
+		return err
+	}
+	return r.Reply(data)
+}
+
+//Filter 添加发送前的处理
+func (r *Response) Filter(filter iface.ResponseFilter) {
+	r.filters = append(r.filters, filter)
+}
+
+//GetConnect 原始连接
+func (r *Response) GetConnect() iface.IConnect {
+	return r.request.GetConnect()
+}
@@ -0,0 +1,61 @@
+package util
+
+import "sync"
+
+//SeqWindow 滑动窗口去重，记录最近size个序列号，重复或者比窗口更早的序列号视为重放
+//每个连接各自有一个窗口，需要跨连接（如断线重连）去重时，可以按会话保存一个SeqWindow自行检查
+type SeqWindow struct {
+	size    uint64
+	highest uint64 // 收到的最大序列号
+	seen    []bool // seq % size 是否已收到
+	lock    sync.Mutex
+}
+
+//NewSeqWindow .
+func NewSeqWindow(size int) *SeqWindow {
+	if size <= 0 {
+		size = 1
+	}
+	return &SeqWindow{
+		size: uint64(size),
+		seen: make([]bool, size),
+	}
+}
+
+//Check 序列号第一次出现时返回true并记录，重复时返回false
+//序列号从1开始，0表示未设置序列号，无法去重，返回false，否则对端把每个重放的包都设置为0就能绕过去重
+func (w *SeqWindow) Check(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	// 新的序列号，窗口向前滑动，清除滑出窗口的记录
+	if seq > w.highest {
+		if seq-w.highest >= w.size {
+			for i := range w.seen {
+				w.seen[i] = false
+			}
+		} else {
+			for s := w.highest + 1; s < seq; s++ {
+				w.seen[s%w.size] = false
+			}
+		}
+		w.highest = seq
+		w.seen[seq%w.size] = true
+		return true
+	}
+
+	// 已经滑出窗口，无法判断，视为重放
+	if w.highest-seq >= w.size {
+		return false
+	}
+
+	if w.seen[seq%w.size] {
+		return false
+	}
+	w.seen[seq%w.size] = true
+	return true
+}
@@ -0,0 +1,27 @@
+package util
+
+import "testing"
+
+func TestSeqWindow(t *testing.T) {
+	window := NewSeqWindow(4)
+
+	cases := []struct {
+		seq  uint64
+		want bool
+	}{
+		{0, false}, // 未设置序列号
+		{1, true},
+		{1, false},
+		{3, true},
+		{2, true},
+		{0, false},
+		{8, true},
+		{4, false}, // 已滑出窗口
+		{7, true},
+	}
+	for _, c := range cases {
+		if got := window.Check(c.seq); got != c.want {
+			t.Fatalf("Check(%d) = %v, want %v", c.seq, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package util
+
+import (
+	"encoding/binary"
+)
+
+//SnappyCompressor snappy块格式，压缩率比gzip低，CPU开销小很多，适合延迟敏感的场景
+//和github.com/golang/snappy的Encode/Decode格式兼容
+type SnappyCompressor struct{}
+
+//NewSnappyCompressor .
+func NewSnappyCompressor() *SnappyCompressor {
+	return &SnappyCompressor{}
+}
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy2   = 0x02
+	snappyTableBits  = 14
+	snappyMaxOffset  = 1<<16 - 1
+)
+
+//Compress 压缩，贪心匹配4字节的重复数据，只使用2字节偏移的复制
+func (s *SnappyCompressor) Compress(data []byte) ([]byte, error) {
+
+	dst := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data)+len(data)/6)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(data)))]
+
+	// 记录每个4字节序列最近出现的位置+1，0表示没有出现过
+	var table [1 << snappyTableBits]int32
+
+	literal := 0
+	for i := 0; i+4 <= len(data); {
+		current := binary.LittleEndian.Uint32(data[i:])
+		hash := (current * 0x1e35a7bd) >> (32 - snappyTableBits)
+		candidate := int(table[hash]) - 1
+		table[hash] = int32(i + 1)
+
+		if candidate < 0 || i-candidate > snappyMaxOffset || binary.LittleEndian.Uint32(data[candidate:]) != current {
+			i++
+			continue
+		}
+
+		length := 4
+		for i+length < len(data) && data[candidate+length] == data[i+length] {
+			length++
+		}
+
+		dst = snappyLiteral(dst, data[literal:i])
+		dst = snappyCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+
+	return snappyLiteral(dst, data[literal:]), nil
+}
+
+//Decompress 解压，开头记录了解压后的长度，超过maxLength时不分配内存直接返回BodyLenExceedLimit，0表示不限制
+func (s *SnappyCompressor) Decompress(data []byte, maxLength int) ([]byte, error) {
+
+	total, n := binary.Uvarint(data)
+	if n <= 0 || total > 1<<31-1 {
+		return nil, SnappyCorrupt
+	}
+	if maxLength > 0 && total > uint64(maxLength) {
+		return nil, BodyLenExceedLimit
+	}
+
+	dst := make([]byte, 0, total)
+	src := data[n:]
+	for len(src) > 0 {
+		var length, offset int
+		tag := src[0]
+
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			x, size := int(tag>>2), 1
+			if x >= 60 {
+				size += x - 59
+				if len(src) < size {
+					return nil, SnappyCorrupt
+				}
+				x = 0
+				for i := 1; i < size; i++ {
+					x |= int(src[i]) << (8 * uint(i-1))
+				}
+			}
+			length = x + 1
+			if length <= 0 || len(src)-size < length || cap(dst)-len(dst) < length {
+				return nil, SnappyCorrupt
+			}
+			dst = append(dst, src[size:size+length]...)
+			src = src[size+length:]
+			continue
+		case 0x01:
+			if len(src) < 2 {
+				return nil, SnappyCorrupt
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case snappyTagCopy2:
+			if len(src) < 3 {
+				return nil, SnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		default:
+			if len(src) < 5 {
+				return nil, SnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) || cap(dst)-len(dst) < length {
+			return nil, SnappyCorrupt
+		}
+
+		// 复制的区域可能和正在写入的区域重叠，需要逐个字节复制
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+
+	if uint64(len(dst)) != total {
+		return nil, SnappyCorrupt
+	}
+	return dst, nil
+}
+
+//snappyLiteral 原样写入的数据，tag的高6位是长度-1，超过60时长度写在之后的1~4个字节中
+func snappyLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+
+	n := len(literal) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+//snappyCopy 复制之前出现过的数据，一次最多复制64字节，剩余部分不少于4字节
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+}